@@ -0,0 +1,196 @@
+package dorametrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	au "github.com/logrusorgru/aurora"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// annotationValidators check the value of each known annotation; nil accepts anything
+var annotationValidators = map[string]func(value string) error{
+	fmt.Sprintf("%s/%s", annotationPrefix, annotationNameReportBefore):          positiveInteger,
+	fmt.Sprintf("%s/%s", annotationPrefix, annotationNameCycleTime):             nonNegativeInteger,
+	fmt.Sprintf("%s/%s", annotationPrefix, annotationNameSuccess):               boolean,
+	fmt.Sprintf("%s/%s", annotationPrefix, annotationNameCommitSHA):             nil,
+	fmt.Sprintf("%s/%s", annotationPrefix, annotationNameCommitTimestamp):       positiveInteger,
+	fmt.Sprintf("%s/%s", annotationPrefix, annotationNameCommitTimestamps):      timestampList,
+	fmt.Sprintf("%s/%s", annotationPrefix, annotationNameAvailabilityThreshold): threshold,
+	fmt.Sprintf("%s/%s", annotationPrefix, annotationNameMaxCycleTime):          nonNegativeInteger,
+	fmt.Sprintf("%s/%s", annotationPrefix, annotationNameMaxTimeToRecovery):     nonNegativeInteger,
+	fmt.Sprintf("%s/%s", annotationPrefix, annotationNameAutoDetect):            boolean,
+	teamKey:        nil,
+	serviceKey:     nil,
+	environmentKey: nil,
+}
+
+// booleanAnnotations are canonicalised by the mutating webhook
+var booleanAnnotations = []string{
+	fmt.Sprintf("%s/%s", annotationPrefix, annotationNameSuccess),
+	fmt.Sprintf("%s/%s", annotationPrefix, annotationNameAutoDetect),
+}
+
+func positiveInteger(value string) error {
+	if number, err := strconv.ParseInt(value, 10, 64); err != nil || number <= 0 {
+		return fmt.Errorf("must be a positive integer")
+	}
+	return nil
+}
+
+func nonNegativeInteger(value string) error {
+	if number, err := strconv.ParseInt(value, 10, 64); err != nil || number < 0 {
+		return fmt.Errorf("must be a non-negative integer")
+	}
+	return nil
+}
+
+func boolean(value string) error {
+	if value != "true" && value != "false" {
+		return fmt.Errorf("must be 'true' or 'false'")
+	}
+	return nil
+}
+
+func timestampList(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if positiveInteger(strings.TrimSpace(item)) != nil {
+			return fmt.Errorf("must be comma-separated unix timestamps")
+		}
+	}
+	return nil
+}
+
+func threshold(value string) error {
+	_, err := ParseThreshold(value)
+	return err
+}
+
+// validateAnnotations lists the problems with dora-controller annotations
+// that are new or changed since previous, which is nil on creation; values
+// set earlier are left alone so that scaling a workload never fails
+func validateAnnotations(annotations map[string]string, previous map[string]string) []string {
+	var problems []string
+	for name, value := range annotations {
+		if !strings.HasPrefix(name, annotationPrefix+"/") {
+			continue
+		}
+		if old, ok := previous[name]; ok && old == value {
+			continue
+		}
+		validator, known := annotationValidators[name]
+		if !known {
+			problems = append(problems, fmt.Sprintf("unknown annotation %s", name))
+			continue
+		}
+		if validator == nil {
+			continue
+		}
+		if err := validator(value); err != nil {
+			problems = append(problems, fmt.Sprintf("annotation %s=%q %v", name, value, err))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// jsonPatchOperation is one operation of an RFC 6902 JSON patch
+type jsonPatchOperation struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value string `json:"value"`
+}
+
+func annotationPath(name string) string {
+	return "/metadata/annotations/" + strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+// defaultAnnotations spells booleans as the controller expects them and sets
+// report-before, if it's missing from a deployment report, to now plus window
+func defaultAnnotations(annotations map[string]string, now time.Time, window time.Duration) []jsonPatchOperation {
+	var patch []jsonPatchOperation
+	for _, name := range booleanAnnotations {
+		value, ok := annotations[name]
+		if !ok {
+			continue
+		}
+		if parsed, err := strconv.ParseBool(strings.TrimSpace(value)); err == nil && strconv.FormatBool(parsed) != value {
+			patch = append(patch, jsonPatchOperation{Op: "replace", Path: annotationPath(name), Value: strconv.FormatBool(parsed)})
+		}
+	}
+
+	reportBefore := fmt.Sprintf("%s/%s", annotationPrefix, annotationNameReportBefore)
+	_, reported := annotations[fmt.Sprintf("%s/%s", annotationPrefix, annotationNameSuccess)]
+	if _, ok := annotations[reportBefore]; reported && !ok && window > 0 {
+		patch = append(patch, jsonPatchOperation{Op: "add", Path: annotationPath(reportBefore), Value: strconv.FormatInt(now.Add(window).Unix(), 10)})
+	}
+	return patch
+}
+
+// AdmissionHandler serves a validating or, with Mutate, a mutating
+// admission webhook for workloads carrying dora-controller annotations
+type AdmissionHandler struct {
+	Mutate       bool
+	ReportWindow time.Duration // how far ahead a missing report-before is set; 0 leaves it missing
+}
+
+func (h *AdmissionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		http.Error(w, "can't read request body", http.StatusBadRequest)
+		return
+	}
+	review := admissionv1.AdmissionReview{}
+	if err := json.Unmarshal(body, &review); err != nil || review.Request == nil {
+		http.Error(w, "can't parse admission review", http.StatusBadRequest)
+		return
+	}
+
+	request := review.Request
+	response := &admissionv1.AdmissionResponse{UID: request.UID, Allowed: true}
+	object, previous := metav1.PartialObjectMetadata{}, metav1.PartialObjectMetadata{}
+	if err := json.Unmarshal(request.Object.Raw, &object); err != nil {
+		http.Error(w, fmt.Sprintf("can't parse object: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(request.OldObject.Raw) > 0 {
+		if err := json.Unmarshal(request.OldObject.Raw, &previous); err != nil {
+			http.Error(w, fmt.Sprintf("can't parse old object: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if h.Mutate {
+		if patch := defaultAnnotations(object.GetAnnotations(), time.Now(), h.ReportWindow); len(patch) > 0 {
+			response.Patch, err = json.Marshal(patch)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("can't encode patch: %v", err), http.StatusInternalServerError)
+				return
+			}
+			patchType := admissionv1.PatchTypeJSONPatch
+			response.PatchType = &patchType
+		}
+	} else if problems := validateAnnotations(object.GetAnnotations(), previous.GetAnnotations()); len(problems) > 0 {
+		message := strings.Join(problems, "; ")
+		log.Println(fmt.Sprintf("%s: rejecting %s %s in namespace %s: %s", au.Bold(au.Cyan("INFO")), request.Kind.Kind, au.Bold(request.Name), au.Bold(request.Namespace), message))
+		response.Allowed = false
+		response.Result = &metav1.Status{Status: metav1.StatusFailure, Reason: metav1.StatusReasonInvalid, Code: http.StatusUnprocessableEntity, Message: message}
+	}
+
+	review.Request = nil
+	review.Response = response
+	writeJSON(w, review)
+}
@@ -0,0 +1,158 @@
+package dorametrics
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestValidateAnnotations(t *testing.T) {
+	var tests = []struct {
+		description string
+		annotations map[string]string
+		previous    map[string]string
+		problems    int
+	}{
+		{"valid", map[string]string{
+			"dora-controller/report-before":          "1626600056",
+			"dora-controller/cycle-time":             "125",
+			"dora-controller/success":                "true",
+			"dora-controller/commit-timestamps":      "1626580000, 1626585000",
+			"dora-controller/availability-threshold": "50%",
+			"dora-controller/team":                   "payments",
+			"kubectl.kubernetes.io/restartedAt":      "now",
+		}, nil, 0},
+		{"report_before", map[string]string{"dora-controller/report-before": "$(date +%s)"}, nil, 1},
+		{"negative_cycle_time", map[string]string{"dora-controller/cycle-time": "-5"}, nil, 1},
+		{"success", map[string]string{"dora-controller/success": "yes"}, nil, 1},
+		{"commit_timestamps", map[string]string{"dora-controller/commit-timestamps": "1626580000,abc"}, nil, 1},
+		{"threshold", map[string]string{"dora-controller/availability-threshold": "150%"}, nil, 1},
+		{"misspelt", map[string]string{"dora-controller/report-befor": "1626600056"}, nil, 1},
+		{"several", map[string]string{"dora-controller/success": "True", "dora-controller/max-cycle-time": "2h"}, nil, 2},
+		{"unchanged", map[string]string{"dora-controller/report-before": "soon"}, map[string]string{"dora-controller/report-before": "soon"}, 0},
+		{"changed", map[string]string{"dora-controller/report-before": "later"}, map[string]string{"dora-controller/report-before": "soon"}, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			problems := validateAnnotations(test.annotations, test.previous)
+			if len(problems) != test.problems {
+				t.Errorf("Unexpected problems %v; expected %d", problems, test.problems)
+			}
+		})
+	}
+}
+
+func TestDefaultAnnotations(t *testing.T) {
+	now := time.Unix(1626600000, 0)
+	var tests = []struct {
+		description string
+		annotations map[string]string
+		window      time.Duration
+		expected    []jsonPatchOperation
+	}{
+		{"complete", map[string]string{"dora-controller/success": "true", "dora-controller/report-before": "1626600056"}, time.Minute, nil},
+		{"unreported", map[string]string{"dora-controller/team": "payments"}, time.Minute, nil},
+		{"report_before", map[string]string{"dora-controller/success": "false"}, time.Minute, []jsonPatchOperation{
+			{"add", "/metadata/annotations/dora-controller~1report-before", "1626600060"},
+		}},
+		{"no_window", map[string]string{"dora-controller/success": "false"}, 0, nil},
+		{"booleans", map[string]string{"dora-controller/success": "True", "dora-controller/auto-detect": "1", "dora-controller/report-before": "1626600056"}, time.Minute, []jsonPatchOperation{
+			{"replace", "/metadata/annotations/dora-controller~1success", "true"},
+			{"replace", "/metadata/annotations/dora-controller~1auto-detect", "true"},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			patch := defaultAnnotations(test.annotations, now, test.window)
+			if len(patch) != len(test.expected) {
+				t.Fatalf("Unexpected patch %+v; expected %+v", patch, test.expected)
+			}
+			for i, operation := range patch {
+				if operation != test.expected[i] {
+					t.Errorf("Unexpected operation %+v; expected %+v", operation, test.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func admissionReview(t *testing.T, annotations map[string]string) []byte {
+	object, err := json.Marshal(deployment("server-a", 2, 2, annotations))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	review := admissionv1.AdmissionReview{Request: &admissionv1.AdmissionRequest{
+		UID:       types.UID("7f0b2ee4"),
+		Name:      "server-a",
+		Namespace: "default",
+		Operation: admissionv1.Update,
+		Object:    runtime.RawExtension{Raw: object},
+	}}
+	review.APIVersion = "admission.k8s.io/v1"
+	review.Kind = "AdmissionReview"
+	body, err := json.Marshal(review)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return body
+}
+
+func TestAdmissionHandler(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/validate", &AdmissionHandler{})
+	mux.Handle("/mutate", &AdmissionHandler{Mutate: true, ReportWindow: time.Minute})
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+
+	var tests = []struct {
+		description string
+		path        string
+		annotations map[string]string
+		allowed     bool
+		patched     bool
+	}{
+		{"valid", "/validate", map[string]string{"dora-controller/report-before": "1626600056"}, true, false},
+		{"invalid", "/validate", map[string]string{"dora-controller/report-before": "tomorrow"}, false, false},
+		{"defaults", "/mutate", map[string]string{"dora-controller/success": "TRUE"}, true, true},
+		{"nothing_to_default", "/mutate", nil, true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			response, err := server.Client().Post(server.URL+test.path, "application/json", bytes.NewReader(admissionReview(t, test.annotations)))
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			defer response.Body.Close()
+			if response.StatusCode != http.StatusOK {
+				t.Fatalf("Unexpected status %d", response.StatusCode)
+			}
+
+			review := admissionv1.AdmissionReview{}
+			if err := json.NewDecoder(response.Body).Decode(&review); err != nil {
+				t.Fatalf("Can't parse response: %v", err)
+			}
+			if review.Response == nil || review.Response.UID != "7f0b2ee4" || review.Kind != "AdmissionReview" {
+				t.Fatalf("Unexpected review %+v", review)
+			}
+			if review.Response.Allowed != test.allowed {
+				t.Errorf("Unexpected allowed=%t; expected %t", review.Response.Allowed, test.allowed)
+			}
+			if !test.allowed && (review.Response.Result == nil || len(review.Response.Result.Message) == 0) {
+				t.Errorf("Expected a message explaining the rejection")
+			}
+			if (len(review.Response.Patch) > 0) != test.patched {
+				t.Errorf("Unexpected patch %s; expected patched=%t", review.Response.Patch, test.patched)
+			}
+		})
+	}
+}
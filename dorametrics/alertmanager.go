@@ -0,0 +1,171 @@
+package dorametrics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	au "github.com/logrusorgru/aurora"
+)
+
+const alertmanagerSource = "alertmanager"
+
+// alertWorkloadLabels name the workload an alert is about, as kube-state-metrics does
+var alertWorkloadLabels = []struct {
+	label string
+	kind  string
+}{
+	{"deployment", KindDeployment},
+	{"statefulset", KindStatefulSet},
+	{"daemonset", KindDaemonSet},
+	{"rollout", KindRollout},
+}
+
+// alertmanagerPayload holds the fields of an Alertmanager webhook notification we use
+type alertmanagerPayload struct {
+	Version string              `json:"version"`
+	Alerts  []alertmanagerAlert `json:"alerts"`
+}
+
+type alertmanagerAlert struct {
+	Status      string            `json:"status"` // firing or resolved
+	Labels      map[string]string `json:"labels"`
+	StartsAt    time.Time         `json:"startsAt"`
+	EndsAt      time.Time         `json:"endsAt"`
+	Fingerprint string            `json:"fingerprint"`
+}
+
+// AlertmanagerHandler receives Alertmanager webhook notifications: a firing
+// alert opens an incident for the workload named by its labels, and its
+// resolution reports time to recovery. Requests are authenticated like
+// deployment events.
+type AlertmanagerHandler struct {
+	Controller     *Controller
+	Secret         string
+	HMACKey        string
+	NamespaceLabel string // defaults to namespace
+	ServiceLabel   string // defaults to service
+	TeamLabel      string // defaults to team
+}
+
+func (h *AlertmanagerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		http.Error(w, "can't read request body", http.StatusBadRequest)
+		return
+	}
+
+	if !authorized(r, body, h.Secret, h.HMACKey) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	payload := alertmanagerPayload{}
+	err = json.Unmarshal(body, &payload)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("can't parse Alertmanager notification: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	h.Controller.Mutex.Lock()
+	defer h.Controller.Mutex.Unlock()
+	now := time.Now().Unix()
+	for _, alert := range payload.Alerts {
+		// alerts we can't map are skipped rather than refused, or Alertmanager would retry them forever
+		incident, ok := h.incident(alert)
+		if !ok {
+			log.Println(fmt.Sprintf("%s: ignoring alert without namespace and workload labels: %v", au.Bold(au.Cyan("INFO")), alert.Labels))
+			continue
+		}
+
+		switch alert.Status {
+		case "firing":
+			// alert labels become metric labels; only workloads we track may add series
+			if !h.Controller.trackedWorkload(incident.Kind, incident.Namespace, incident.Service) {
+				log.Println(fmt.Sprintf("%s: ignoring alert for %s %s in namespace %s, which is not tracked", au.Bold(au.Cyan("INFO")), incident.Kind, au.Bold(incident.Service), au.Bold(incident.Namespace)))
+				continue
+			}
+			incident.Start = alert.StartsAt.Unix()
+			if alert.StartsAt.IsZero() || incident.Start > now {
+				incident.Start = now
+			}
+			h.Controller.openIncident(incident)
+		case "resolved":
+			end := alert.EndsAt.Unix()
+			if alert.EndsAt.IsZero() || end > now {
+				end = now
+			}
+			h.Controller.resolveIncident(alertmanagerSource, incident.ID, end)
+		default:
+			log.Println(fmt.Sprintf("%s: ignoring alert with status %s", au.Bold(au.Cyan("INFO")), alert.Status))
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// incident maps an alert to an incident on a workload: the namespace label
+// and a deployment, statefulset, daemonset or rollout label, falling back to
+// a Deployment named by the service label. Team and service labels on the
+// alert take precedence over the workload's.
+func (h *AlertmanagerHandler) incident(alert alertmanagerAlert) (IncidentInfo, bool) {
+	namespaceLabel := firstNonEmpty(h.NamespaceLabel, "namespace")
+	serviceLabel := firstNonEmpty(h.ServiceLabel, "service")
+	teamLabel := firstNonEmpty(h.TeamLabel, "team")
+
+	incident := IncidentInfo{
+		ID:        alert.Fingerprint,
+		Source:    alertmanagerSource,
+		Namespace: alert.Labels[namespaceLabel],
+		Labels:    map[string]string{},
+	}
+	for _, workload := range alertWorkloadLabels {
+		if name := alert.Labels[workload.label]; len(name) > 0 {
+			incident.Service, incident.Kind = name, workload.kind
+			break
+		}
+	}
+	if service := alert.Labels[serviceLabel]; len(service) > 0 {
+		if len(incident.Service) == 0 {
+			incident.Service, incident.Kind = service, KindDeployment
+		}
+		incident.Labels["service"] = service
+	}
+	if team := alert.Labels[teamLabel]; len(team) > 0 {
+		incident.Labels["team"] = team
+	}
+	if len(incident.Namespace) == 0 || len(incident.Service) == 0 {
+		return incident, false
+	}
+	if len(incident.ID) == 0 {
+		incident.ID = labelsFingerprint(alert.Labels)
+	}
+	return incident, true
+}
+
+// labelsFingerprint identifies an alert by its label set, for senders that
+// don't provide Alertmanager's fingerprint
+func labelsFingerprint(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	hash := sha256.New()
+	for _, name := range names {
+		fmt.Fprintf(hash, "%s=%s\n", name, labels[name])
+	}
+	return hex.EncodeToString(hash.Sum(nil))[:16]
+}
@@ -0,0 +1,110 @@
+package dorametrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func postAlerts(t *testing.T, handler http.Handler, token string, body string) int {
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/alerts", strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer "+token)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder.Code
+}
+
+func TestAlertmanagerHandler(t *testing.T) {
+	c := newTestController(t)
+	c.Indexers[KindDeployment].Add(deployment("server-a", 2, 2, nil))
+	handler := &AlertmanagerHandler{Controller: c, Secret: "s3cret"}
+	labels := c.metricLabels("server-a", "default", KindDeployment)
+	labels["team"] = "payments"
+	recoveryLabels := withSource(withState(labels, availabilityDown), alertmanagerSource)
+
+	firing := `{"version":"4","status":"firing","alerts":[
+		{"status":"firing","labels":{"alertname":"HighErrorRate","namespace":"default","deployment":"server-a","team":"payments"},
+		 "startsAt":"2022-03-01T10:00:00Z","endsAt":"0001-01-01T00:00:00Z","fingerprint":"a1b2c3"},
+		{"status":"firing","labels":{"alertname":"NodeDown","instance":"10.0.0.1"},"startsAt":"2022-03-01T10:00:00Z"},
+		{"status":"firing","labels":{"alertname":"HighErrorRate","namespace":"default","deployment":"server-z"},"startsAt":"2022-03-01T10:00:00Z"}]}`
+	resolved := `{"version":"4","status":"resolved","alerts":[
+		{"status":"resolved","labels":{"alertname":"HighErrorRate","namespace":"default","deployment":"server-a","team":"payments"},
+		 "startsAt":"2022-03-01T10:00:00Z","endsAt":"2022-03-01T10:15:00Z","fingerprint":"a1b2c3"}]}`
+
+	if status := postAlerts(t, handler, "guess", firing); status != http.StatusUnauthorized {
+		t.Errorf("Unexpected status %d for a wrong token; expected %d", status, http.StatusUnauthorized)
+	}
+	if status := postAlerts(t, handler, "s3cret", `{"alerts":`); status != http.StatusBadRequest {
+		t.Errorf("Unexpected status %d for a malformed notification; expected %d", status, http.StatusBadRequest)
+	}
+
+	// repeated notifications don't open the incident twice
+	for i := 0; i < 2; i++ {
+		if status := postAlerts(t, handler, "s3cret", firing); status != http.StatusOK {
+			t.Fatalf("Unexpected status %d for firing alerts", status)
+		}
+	}
+	// neither the unmapped alert nor the one for an untracked workload opens an incident
+	if len(c.Incidents) != 1 {
+		t.Fatalf("Unexpected incidents %+v; expected one", c.Incidents)
+	}
+	if value := testutil.ToFloat64(c.Collectors.DowntimeCounter.With(withSource(withState(labels, availabilityDown), alertmanagerSource))); value != 1 {
+		t.Errorf("Unexpected downtime count %v; expected 1", value)
+	}
+	if count := testutil.CollectAndCount(&c.Collectors.DowntimeCounter); count != 1 {
+		t.Errorf("Unexpected number of downtime series %d; expected 1", count)
+	}
+
+	if status := postAlerts(t, handler, "s3cret", resolved); status != http.StatusOK {
+		t.Fatalf("Unexpected status %d for resolved alerts", status)
+	}
+	if len(c.Incidents) != 0 {
+		t.Errorf("Expected no open incidents; got %+v", c.Incidents)
+	}
+	if value := testutil.ToFloat64(c.Collectors.TimeToRecoveryGauge.With(recoveryLabels)); value != 900 {
+		t.Errorf("Unexpected time to recovery %v; expected 900", value)
+	}
+	if count := testutil.CollectAndCount(&c.Collectors.TimeToRecoveryHistogram); count != 1 {
+		t.Errorf("Unexpected number of time to recovery series %d; expected 1", count)
+	}
+}
+
+func TestAlertIncident(t *testing.T) {
+	handler := &AlertmanagerHandler{NamespaceLabel: "kubernetes_namespace"}
+	var tests = []struct {
+		description string
+		labels      map[string]string
+		mapped      bool
+		kind        string
+		name        string
+		service     string
+	}{
+		{"deployment", map[string]string{"kubernetes_namespace": "default", "deployment": "server-a"}, true, KindDeployment, "server-a", ""},
+		{"statefulset", map[string]string{"kubernetes_namespace": "default", "statefulset": "db", "service": "orders"}, true, KindStatefulSet, "db", "orders"},
+		{"service_only", map[string]string{"kubernetes_namespace": "default", "service": "orders"}, true, KindDeployment, "orders", "orders"},
+		{"no_namespace", map[string]string{"namespace": "default", "deployment": "server-a"}, false, "", "", ""},
+		{"no_workload", map[string]string{"kubernetes_namespace": "default"}, false, "", "", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			incident, ok := handler.incident(alertmanagerAlert{Labels: test.labels})
+			if ok != test.mapped {
+				t.Fatalf("Unexpected mapped=%t; expected %t", ok, test.mapped)
+			}
+			if !ok {
+				return
+			}
+			if incident.Kind != test.kind || incident.Service != test.name || incident.Labels["service"] != test.service {
+				t.Errorf("Unexpected incident %+v", incident)
+			}
+			// without Alertmanager's fingerprint, the label set identifies the alert
+			if incident.ID != labelsFingerprint(test.labels) {
+				t.Errorf("Unexpected incident id %s", incident.ID)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package dorametrics
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const annotationNameAvailabilityThreshold = "availability-threshold"
+
+// availability states; only down and degraded are outages
+const (
+	availabilityDown      = "down"
+	availabilityDegraded  = "degraded"
+	availabilityPartial   = "partial"
+	availabilityAvailable = "available"
+)
+
+// availabilityState classifies a workload by the share of ready replicas:
+// down without any, degraded below the threshold, available with all of
+// them and partial in between; a threshold of 0 disables the degraded state
+func availabilityState(replicas int32, readyReplicas int32, threshold float64) string {
+	switch {
+	case replicas > 0 && readyReplicas == 0:
+		return availabilityDown
+	case replicas > 0 && float64(readyReplicas)/float64(replicas) < threshold:
+		return availabilityDegraded
+	case readyReplicas >= replicas:
+		return availabilityAvailable
+	}
+	return availabilityPartial
+}
+
+// ParseThreshold accepts a fraction ("0.5") or a percentage ("50%")
+func ParseThreshold(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	divisor := 1.0
+	if strings.HasSuffix(value, "%") {
+		value = strings.TrimSuffix(value, "%")
+		divisor = 100
+	}
+	threshold, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("can't parse threshold %s: %v", value, err)
+	}
+	threshold /= divisor
+	if threshold < 0 || threshold > 1 {
+		return 0, fmt.Errorf("threshold %s must be between 0 and 1 (or 0%% and 100%%)", value)
+	}
+	return threshold, nil
+}
+
+// availabilityThreshold resolves the threshold for a workload: annotation,
+// configured target, configuration file, then the command line default
+func (c *Controller) availabilityThreshold(kind string, namespace string, name string, annotations map[string]string) (float64, error) {
+	if value, ok := annotations[fmt.Sprintf("%s/%s", annotationPrefix, annotationNameAvailabilityThreshold)]; ok {
+		return ParseThreshold(value)
+	}
+	if c.Config != nil {
+		if target, ok := c.Config.findTarget(kind, namespace, name); ok && target.AvailabilityThreshold > 0 {
+			return target.AvailabilityThreshold, nil
+		}
+		if c.Config.AvailabilityThreshold > 0 {
+			return c.Config.AvailabilityThreshold, nil
+		}
+	}
+	return c.DefaultAvailabilityThreshold, nil
+}
+
+// withState adds the availability state to a copy of the metric labels
+func withState(metricLabels prometheus.Labels, state string) prometheus.Labels {
+	labels := prometheus.Labels{"state": state}
+	for name, value := range metricLabels {
+		labels[name] = value
+	}
+	return labels
+}
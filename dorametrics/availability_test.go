@@ -0,0 +1,106 @@
+package dorametrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAvailabilityState(t *testing.T) {
+	var tests = []struct {
+		description   string
+		replicas      int32
+		readyReplicas int32
+		threshold     float64
+		state         string
+	}{
+		{"all_ready", 10, 10, 0.5, availabilityAvailable},
+		{"none_ready", 10, 0, 0.5, availabilityDown},
+		{"below_threshold", 10, 1, 0.5, availabilityDegraded},
+		{"at_threshold", 10, 5, 0.5, availabilityPartial},
+		{"no_threshold", 10, 1, 0, availabilityPartial},
+		{"scaled_to_zero", 0, 0, 0.5, availabilityAvailable},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			state := availabilityState(test.replicas, test.readyReplicas, test.threshold)
+			if state != test.state {
+				t.Errorf("Unexpected state %s for %d/%d ready with threshold %v; expected %s", state, test.readyReplicas, test.replicas, test.threshold, test.state)
+			}
+		})
+	}
+}
+
+func TestParseThreshold(t *testing.T) {
+	var tests = []struct {
+		description string
+		value       string
+		threshold   float64
+		valid       bool
+	}{
+		{"fraction", "0.5", 0.5, true},
+		{"percentage", "75%", 0.75, true},
+		{"too_large", "150%", 0, false},
+		{"negative", "-0.1", 0, false},
+		{"garbage", "half", 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			threshold, err := ParseThreshold(test.value)
+			if (err == nil) != test.valid {
+				t.Fatalf("Unexpected error %v for %s", err, test.value)
+			}
+			if threshold != test.threshold {
+				t.Errorf("Unexpected threshold %v for %s; expected %v", threshold, test.value, test.threshold)
+			}
+		})
+	}
+}
+
+func TestDegradedOutage(t *testing.T) {
+	c := newTestController(t)
+	labels := c.metricLabels("server-a", "default", KindDeployment)
+	key := "Deployment/default/server-a"
+	annotations := map[string]string{"dora-controller/availability-threshold": "50%"}
+	sync := func(readyReplicas int32) {
+		c.Indexers[KindDeployment].Add(deployment("server-a", 10, readyReplicas, annotations))
+		if err := c.syncToStdout(key); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	sync(10)
+	sync(1)
+	if c.State[key].ErrorState != availabilityDegraded {
+		t.Errorf("Unexpected error state '%s'; expected degraded", c.State[key].ErrorState)
+	}
+	// escalation and partial recovery keep the outage open
+	sync(0)
+	sync(6)
+	if c.State[key].ErrorState != availabilityDown {
+		t.Errorf("Unexpected error state '%s'; expected down", c.State[key].ErrorState)
+	}
+	sync(10)
+	if c.State[key].ErrorStart != 0 {
+		t.Errorf("Expected outage to be closed")
+	}
+
+	var tests = []struct {
+		state    string
+		downtime float64
+		recovery uint64
+	}{
+		{availabilityDegraded, 1, 0},
+		{availabilityDown, 0, 1},
+	}
+	for _, test := range tests {
+		if value := testutil.ToFloat64(c.Collectors.DowntimeCounter.With(withSource(withState(labels, test.state), recoverySourcePods))); value != test.downtime {
+			t.Errorf("Unexpected %s downtime count %v; expected %v", test.state, value, test.downtime)
+		}
+		if count := sampleCount(t, c.Collectors.TimeToRecoveryHistogram.With(withSource(withState(labels, test.state), recoverySourcePods))); count != test.recovery {
+			t.Errorf("Unexpected number of %s recoveries %d; expected %d", test.state, count, test.recovery)
+		}
+	}
+}
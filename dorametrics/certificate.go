@@ -0,0 +1,80 @@
+package dorametrics
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	au "github.com/logrusorgru/aurora"
+)
+
+const certificateCheckInterval = 10 * time.Second
+
+// CertificateReloader serves a TLS key pair from files and reads them again
+// when their content changes, so that a renewed certificate is used without
+// a restart. Secret volumes swap a symlink rather than writing in place, so
+// we compare content rather than modification times.
+type CertificateReloader struct {
+	CertFile string
+	KeyFile  string
+
+	mutex       sync.Mutex
+	certificate *tls.Certificate
+	checksums   [2][sha256.Size]byte
+	checkedAt   time.Time
+}
+
+// NewCertificateReloader loads the key pair, which must be valid
+func NewCertificateReloader(certFile string, keyFile string) (*CertificateReloader, error) {
+	reloader := &CertificateReloader{CertFile: certFile, KeyFile: keyFile}
+	if err := reloader.reload(time.Now()); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// GetCertificate returns the current key pair, checking the files at most
+// every certificateCheckInterval; it's meant for tls.Config.GetCertificate
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if now := time.Now(); now.Sub(r.checkedAt) >= certificateCheckInterval {
+		if err := r.reload(now); err != nil {
+			// the files may be half way through an update; try again later
+			log.Println(fmt.Sprintf("%s: keeping previous certificate: %v", au.Bold(au.Red("Error")), err))
+		}
+	}
+	return r.certificate, nil
+}
+
+// reload reads the key pair if either file changed; callers hold r.mutex
+// unless the reloader is being created
+func (r *CertificateReloader) reload(now time.Time) error {
+	r.checkedAt = now
+	certChecksum, err := fileChecksum(r.CertFile)
+	if err != nil {
+		return fmt.Errorf("can't read certificate %s: %v", r.CertFile, err)
+	}
+	keyChecksum, err := fileChecksum(r.KeyFile)
+	if err != nil {
+		return fmt.Errorf("can't read key %s: %v", r.KeyFile, err)
+	}
+	checksums := [2][sha256.Size]byte{certChecksum, keyChecksum}
+	if r.certificate != nil && checksums == r.checksums {
+		return nil
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return fmt.Errorf("can't load key pair %s and %s: %v", r.CertFile, r.KeyFile, err)
+	}
+	if r.certificate != nil {
+		log.Println(fmt.Sprintf("%s: reloaded certificate %s", au.Bold(au.Cyan("INFO")), r.CertFile))
+	}
+	r.certificate = &certificate
+	r.checksums = checksums
+	return nil
+}
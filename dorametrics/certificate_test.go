@@ -0,0 +1,90 @@
+package dorametrics
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeKeyPair writes a self-signed certificate for commonName and its key
+func writeKeyPair(t *testing.T, certFile string, keyFile string, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Can't generate key: %v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Can't create certificate: %v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Can't marshal key: %v", err)
+	}
+	writeConfig(t, certFile, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})))
+	writeConfig(t, keyFile, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})))
+}
+
+func TestCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+	writeKeyPair(t, certFile, keyFile, "first")
+
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	commonName := func() string {
+		// pretend the check interval has passed
+		reloader.mutex.Lock()
+		reloader.checkedAt = time.Time{}
+		reloader.mutex.Unlock()
+		certificate, err := reloader.GetCertificate(&tls.ClientHelloInfo{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			t.Fatalf("Can't parse certificate: %v", err)
+		}
+		return leaf.Subject.CommonName
+	}
+	if name := commonName(); name != "first" {
+		t.Errorf("Unexpected certificate %s; expected first", name)
+	}
+
+	writeKeyPair(t, certFile, keyFile, "renewed")
+	if name := commonName(); name != "renewed" {
+		t.Errorf("Unexpected certificate %s; expected renewed", name)
+	}
+
+	// a certificate without its key is not used
+	certificate, _ := ioutil.ReadFile(certFile)
+	writeKeyPair(t, certFile, filepath.Join(dir, "other.key"), "mismatched")
+	if name := commonName(); name != "renewed" {
+		t.Errorf("Unexpected certificate %s; expected to keep renewed", name)
+	}
+	if current, _ := ioutil.ReadFile(certFile); bytes.Equal(current, certificate) {
+		t.Fatalf("Expected the certificate file to change")
+	}
+
+	if _, err := NewCertificateReloader(filepath.Join(dir, "missing.crt"), keyFile); err == nil {
+		t.Errorf("Expected an error for a missing certificate")
+	}
+}
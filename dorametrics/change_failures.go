@@ -0,0 +1,112 @@
+package dorametrics
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	au "github.com/logrusorgru/aurora"
+)
+
+// serviceWorkload finds the tracked workload of a service, by its service
+// label or its name, optionally within a namespace; of several, the one
+// deployed most recently wins. Callers hold c.Mutex.
+func (c *Controller) serviceWorkload(service string, namespace string) (string, DeploymentInfo, bool) {
+	keys := make([]string, 0, len(c.State))
+	for key := range c.State {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	found := ""
+	for _, key := range keys {
+		info := c.State[key]
+		if info.DeletedAt > 0 || (len(namespace) > 0 && info.Namespace != namespace) {
+			continue
+		}
+		if info.Name != service && c.metricLabels(info.Name, info.Namespace, info.Kind)["service"] != service {
+			continue
+		}
+		if len(found) == 0 || info.LastDeployedAt > c.State[found].LastDeployedAt {
+			found = key
+		}
+	}
+	if len(found) == 0 {
+		return "", DeploymentInfo{}, false
+	}
+	return found, c.State[found], true
+}
+
+// openServiceIncident opens an incident reported for a service and blames
+// the service's most recent deployment for it if that was within the change
+// failure window; callers hold c.Mutex
+func (c *Controller) openServiceIncident(source string, id string, service string, namespace string, start int64, labels map[string]string) {
+	if _, ok := c.Incidents[incidentKey(source, id)]; ok {
+		if c.Debug {
+			log.Println(fmt.Sprintf("%s: incident %s already open", au.Bold(au.Cyan("INFO")), incidentKey(source, id)))
+		}
+		return
+	}
+	key, info, ok := c.serviceWorkload(service, namespace)
+	if !ok {
+		log.Println(fmt.Sprintf("%s: ignoring incident %s for unknown service %s", au.Bold(au.Cyan("INFO")), incidentKey(source, id), au.Bold(service)))
+		return
+	}
+
+	c.openIncident(IncidentInfo{
+		ID:        id,
+		Source:    source,
+		Service:   info.Name,
+		Namespace: info.Namespace,
+		Kind:      info.Kind,
+		Start:     start,
+		Labels:    labels,
+	})
+	window := c.changeFailureWindow(info.Kind, info.Namespace, info.Name)
+	if window > 0 && start-info.LastDeployedAt <= window && c.markChangeFailure(&info, source, id, start) {
+		c.State[key] = info
+		c.persistState()
+	}
+}
+
+// markChangeFailure counts the workload's last successful deployment as a
+// change failure, once, if it preceded the incident; it reports whether it
+// did, leaving callers, who hold c.Mutex, to save info
+func (c *Controller) markChangeFailure(info *DeploymentInfo, source string, incidentID string, at int64) bool {
+	if info.LastDeployedAt == 0 || info.LastDeployedAt > at || info.ChangeFailureAt == info.LastDeployedAt {
+		return false
+	}
+
+	log.Println(fmt.Sprintf("%s: counting the deployment of %s %s in namespace %s at %d as a change failure (incident reported by %s)", au.Bold(au.Cyan("INFO")), info.Kind, au.Bold(info.Name), au.Bold(info.Namespace), info.LastDeployedAt, source))
+	info.ChangeFailureAt = info.LastDeployedAt
+	metricLabels := c.metricLabels(info.Name, info.Namespace, info.Kind)
+	c.Collectors.ChangeFailureCounter.With(withSource(metricLabels, source)).Inc()
+	c.recordEvent(EventRecord{
+		Type:       EventChangeFailure,
+		Time:       at,
+		Kind:       info.Kind,
+		Namespace:  info.Namespace,
+		Name:       info.Name,
+		IncidentID: incidentID,
+		Source:     source,
+		DeployedAt: info.LastDeployedAt,
+	}, metricLabels)
+	return true
+}
+
+// changeFailureWindow resolves how long after a successful deployment an
+// outage of the workload's pods is blamed on it, in seconds: configured
+// target, configuration file, then the command line default. 0 blames none.
+func (c *Controller) changeFailureWindow(kind string, namespace string, name string) int64 {
+	window := int64(c.DefaultChangeFailureWindow.Seconds())
+	if c.Config == nil {
+		return window
+	}
+	if c.Config.ChangeFailureWindowSeconds != nil {
+		window = *c.Config.ChangeFailureWindowSeconds
+	}
+	if target, ok := c.Config.findTarget(kind, namespace, name); ok && target.ChangeFailureWindowSeconds != nil {
+		window = *target.ChangeFailureWindowSeconds
+	}
+	return window
+}
@@ -0,0 +1,214 @@
+package dorametrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	au "github.com/logrusorgru/aurora"
+)
+
+const cloudEventsSource = "cdevents"
+const cloudEventsContentType = "application/cloudevents+json"
+
+// CDEvents types we understand, without the trailing spec version
+const (
+	cdEventServiceDeployed   = "dev.cdevents.service.deployed."
+	cdEventServiceUpgraded   = "dev.cdevents.service.upgraded."
+	cdEventServiceRolledBack = "dev.cdevents.service.rolledback."
+	cdEventIncidentDetected  = "dev.cdevents.incident.detected."
+	cdEventIncidentResolved  = "dev.cdevents.incident.resolved."
+)
+
+// cloudEvent holds the CloudEvents attributes we use
+type cloudEvent struct {
+	SpecVersion string          `json:"specversion"`
+	Type        string          `json:"type"`
+	Source      string          `json:"source"`
+	ID          string          `json:"id"`
+	Time        string          `json:"time"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// cdEvent holds the CDEvents fields we use; customData carries what the
+// spec has no field for, such as the Kubernetes namespace and cycle time
+type cdEvent struct {
+	Context struct {
+		Timestamp string `json:"timestamp"`
+	} `json:"context"`
+	Subject struct {
+		ID      string `json:"id"`
+		Content struct {
+			Environment struct {
+				ID string `json:"id"`
+			} `json:"environment"`
+			Service struct {
+				ID string `json:"id"`
+			} `json:"service"`
+		} `json:"content"`
+	} `json:"subject"`
+	CustomData struct {
+		Namespace        string  `json:"namespace"`
+		Kind             string  `json:"kind"`
+		CycleTimeSeconds int64   `json:"cycleTimeSeconds"`
+		CommitSHA        string  `json:"commitSha"`
+		CommitTimestamps []int64 `json:"commitTimestamps"`
+	} `json:"customData"`
+}
+
+// CloudEventsHandler receives CDEvents over the CloudEvents HTTP binding in
+// binary or structured content mode; authentication matches IngestHandler
+type CloudEventsHandler struct {
+	Controller *Controller
+	Secret     string
+	HMACKey    string
+}
+
+func (h *CloudEventsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		http.Error(w, "can't read request body", http.StatusBadRequest)
+		return
+	}
+
+	if !authorized(r, body, h.Secret, h.HMACKey) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	event, err := parseCloudEvent(r, body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = h.handle(event)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// parseCloudEvent reads a structured mode envelope or binary mode ce-* headers
+func parseCloudEvent(r *http.Request, body []byte) (cloudEvent, error) {
+	event := cloudEvent{}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == cloudEventsContentType {
+		err := json.Unmarshal(body, &event)
+		if err != nil {
+			return event, fmt.Errorf("can't parse cloud event: %v", err)
+		}
+	} else {
+		event.SpecVersion = r.Header.Get("Ce-Specversion")
+		event.Type = r.Header.Get("Ce-Type")
+		event.Source = r.Header.Get("Ce-Source")
+		event.ID = r.Header.Get("Ce-Id")
+		event.Time = r.Header.Get("Ce-Time")
+		event.Data = body
+	}
+
+	if len(event.SpecVersion) == 0 || len(event.Type) == 0 || len(event.ID) == 0 {
+		return event, fmt.Errorf("cloud event requires specversion, type and id")
+	}
+	return event, nil
+}
+
+// eventTime prefers the CloudEvents time, then the CDEvents timestamp
+func eventTime(event cloudEvent, data cdEvent) int64 {
+	for _, value := range []string{event.Time, data.Context.Timestamp} {
+		timestamp, err := time.Parse(time.RFC3339, value)
+		if err == nil {
+			return timestamp.Unix()
+		}
+	}
+	return time.Now().Unix()
+}
+
+// lastPathElement turns subject ids such as "service/server-a" into "server-a"
+func lastPathElement(id string) string {
+	return id[strings.LastIndex(id, "/")+1:]
+}
+
+func (h *CloudEventsHandler) handle(event cloudEvent) error {
+	data := cdEvent{}
+	if len(event.Data) > 0 {
+		err := json.Unmarshal(event.Data, &data)
+		if err != nil {
+			return fmt.Errorf("can't parse CDEvent: %v", err)
+		}
+	}
+
+	namespace := data.CustomData.Namespace
+	if len(namespace) == 0 {
+		namespace = data.Subject.Content.Environment.ID
+	}
+	kind := data.CustomData.Kind
+	if len(kind) == 0 {
+		kind = KindDeployment
+	}
+
+	h.Controller.Mutex.Lock()
+	defer h.Controller.Mutex.Unlock()
+
+	switch {
+	case strings.HasPrefix(event.Type, cdEventServiceDeployed),
+		strings.HasPrefix(event.Type, cdEventServiceUpgraded),
+		strings.HasPrefix(event.Type, cdEventServiceRolledBack):
+		deployment := DeploymentEvent{
+			Service:          lastPathElement(data.Subject.ID),
+			Namespace:        namespace,
+			Kind:             kind,
+			Success:          !strings.HasPrefix(event.Type, cdEventServiceRolledBack),
+			CycleTimeSeconds: data.CustomData.CycleTimeSeconds,
+			CommitSHA:        data.CustomData.CommitSHA,
+			CommitTimestamps: data.CustomData.CommitTimestamps,
+			FinishedAt:       eventTime(event, data),
+		}
+		err := validateDeploymentEvent(&deployment)
+		if err != nil {
+			return err
+		}
+		if !h.Controller.trackedWorkload(deployment.Kind, deployment.Namespace, deployment.Service) {
+			return fmt.Errorf("%s %s in namespace %s is not tracked", deployment.Kind, deployment.Service, deployment.Namespace)
+		}
+		h.Controller.reportDeployment(deployment)
+	case strings.HasPrefix(event.Type, cdEventIncidentDetected):
+		incident := IncidentInfo{
+			ID:        data.Subject.ID,
+			Source:    cloudEventsSource,
+			Service:   lastPathElement(data.Subject.Content.Service.ID),
+			Namespace: namespace,
+			Kind:      kind,
+			Start:     eventTime(event, data),
+		}
+		if len(incident.ID) == 0 || len(incident.Service) == 0 || len(incident.Namespace) == 0 {
+			return fmt.Errorf("incident requires subject id, service and namespace")
+		}
+		if !h.Controller.trackedWorkload(incident.Kind, incident.Namespace, incident.Service) {
+			return fmt.Errorf("%s %s in namespace %s is not tracked", incident.Kind, incident.Service, incident.Namespace)
+		}
+		h.Controller.openIncident(incident)
+	case strings.HasPrefix(event.Type, cdEventIncidentResolved):
+		if len(data.Subject.ID) == 0 {
+			return fmt.Errorf("incident requires subject id")
+		}
+		h.Controller.resolveIncident(cloudEventsSource, data.Subject.ID, eventTime(event, data))
+	default:
+		if h.Controller.Debug {
+			log.Println(fmt.Sprintf("%s: ignoring cloud event of type %s", au.Bold(au.Cyan("INFO")), event.Type))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package dorametrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func postCloudEvent(t *testing.T, handler http.Handler, headers map[string]string, body string) int {
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/events", strings.NewReader(body))
+	request.Header.Set("Authorization", "Bearer s3cret")
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder.Code
+}
+
+func TestCloudEventsHandler(t *testing.T) {
+	c := newTestController(t)
+	c.Indexers[KindDeployment].Add(deployment("server-a", 2, 2, nil))
+	handler := &CloudEventsHandler{Controller: c, Secret: "s3cret"}
+	labels := c.metricLabels("server-a", "default", KindDeployment)
+
+	// structured content mode
+	deployed := `{"specversion":"1.0","type":"dev.cdevents.service.deployed.0.1.1","source":"/ci","id":"1",
+		"data":{"subject":{"id":"service/server-a","content":{"environment":{"id":"production"}}},
+		"customData":{"namespace":"default","cycleTimeSeconds":240}}}`
+	if status := postCloudEvent(t, handler, map[string]string{"Content-Type": "application/cloudevents+json"}, deployed); status != http.StatusAccepted {
+		t.Fatalf("Unexpected status %d for service.deployed", status)
+	}
+	if value := testutil.ToFloat64(c.Collectors.SuccessCounter.With(labels)); value != 1 {
+		t.Errorf("Unexpected success count %v; expected 1", value)
+	}
+	if value := testutil.ToFloat64(c.Collectors.CycleTimeGauge.With(labels)); value != 240 {
+		t.Errorf("Unexpected cycle time %v; expected 240", value)
+	}
+	untracked := strings.Replace(deployed, "service/server-a", "service/server-x", 1)
+	if status := postCloudEvent(t, handler, map[string]string{"Content-Type": "application/cloudevents+json"}, untracked); status != http.StatusBadRequest {
+		t.Errorf("Unexpected status %d for an untracked workload; expected %d", status, http.StatusBadRequest)
+	}
+
+	// binary content mode
+	binary := map[string]string{
+		"Content-Type":   "application/json",
+		"Ce-Specversion": "1.0",
+		"Ce-Type":        "dev.cdevents.service.rolledback.0.1.1",
+		"Ce-Source":      "/ci",
+		"Ce-Id":          "2",
+	}
+	rolledBack := `{"subject":{"id":"service/server-a"},"customData":{"namespace":"default"}}`
+	if status := postCloudEvent(t, handler, binary, rolledBack); status != http.StatusAccepted {
+		t.Fatalf("Unexpected status %d for service.rolledback", status)
+	}
+	if value := testutil.ToFloat64(c.Collectors.FailureCounter.With(labels)); value != 1 {
+		t.Errorf("Unexpected failure count %v; expected 1", value)
+	}
+
+	// incident lifecycle
+	detected := `{"specversion":"1.0","type":"dev.cdevents.incident.detected.0.1.0","source":"/monitoring","id":"3","time":"2022-03-01T10:00:00Z",
+		"data":{"subject":{"id":"INC-42","content":{"service":{"id":"service/server-a"},"environment":{"id":"default"}}}}}`
+	resolved := `{"specversion":"1.0","type":"dev.cdevents.incident.resolved.0.1.0","source":"/monitoring","id":"4","time":"2022-03-01T10:10:00Z",
+		"data":{"subject":{"id":"INC-42"}}}`
+	structured := map[string]string{"Content-Type": "application/cloudevents+json"}
+	if status := postCloudEvent(t, handler, structured, detected); status != http.StatusAccepted {
+		t.Fatalf("Unexpected status %d for incident.detected", status)
+	}
+	if _, ok := c.Incidents["cdevents/INC-42"]; !ok {
+		t.Fatalf("Expected incident to be open")
+	}
+	untrackedIncident := strings.Replace(strings.Replace(detected, "service/server-a", "service/server-x", 1), "INC-42", "INC-43", 1)
+	if status := postCloudEvent(t, handler, structured, untrackedIncident); status != http.StatusBadRequest {
+		t.Errorf("Unexpected status %d for an incident of an untracked workload; expected %d", status, http.StatusBadRequest)
+	}
+	if _, ok := c.Incidents["cdevents/INC-43"]; ok {
+		t.Errorf("Expected no incident for an untracked workload")
+	}
+	if value := testutil.ToFloat64(c.Collectors.DowntimeCounter.With(withSource(withState(labels, availabilityDown), cloudEventsSource))); value != 1 {
+		t.Errorf("Unexpected downtime count %v; expected 1", value)
+	}
+	if status := postCloudEvent(t, handler, structured, resolved); status != http.StatusAccepted {
+		t.Fatalf("Unexpected status %d for incident.resolved", status)
+	}
+	if value := testutil.ToFloat64(c.Collectors.TimeToRecoveryGauge.With(withSource(withState(labels, availabilityDown), cloudEventsSource))); value != 600 {
+		t.Errorf("Unexpected time to recovery %v; expected 600", value)
+	}
+	if len(c.Incidents) != 0 {
+		t.Errorf("Expected no open incidents; got %d", len(c.Incidents))
+	}
+
+	// malformed envelope
+	if status := postCloudEvent(t, handler, structured, `{"type":"dev.cdevents.service.deployed.0.1.1"}`); status != http.StatusBadRequest {
+		t.Errorf("Unexpected status %d for envelope without id", status)
+	}
+}
@@ -4,16 +4,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"math"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"time"
 
 	au "github.com/logrusorgru/aurora"
-	"github.com/prometheus/client_golang/prometheus"
 
-	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
@@ -25,30 +24,157 @@ const annotationPrefix = "dora-controller"
 const annotationNameReportBefore = "report-before"
 const annotationNameCycleTime = "cycle-time"
 const annotationNameSuccess = "success"
-const maxCycleTimeSeconds = 7200
-const maxTimeToRecoverySeconds = 7200
+const annotationNameCommitSHA = "commit-sha"
+const annotationNameCommitTimestamp = "commit-timestamp"
+const annotationNameCommitTimestamps = "commit-timestamps"
+
+// verifyRetryInterval paces pod checks for reported successes awaiting confirmation
+const verifyRetryInterval = 10 * time.Second
+
+// defaultPersistInterval is the shortest time between two writes to the state store
+const defaultPersistInterval = 5 * time.Second
+
+// maxIncidentAge bounds how long an unresolved incident is kept
+const maxIncidentAge = 30 * 24 * time.Hour
 
 // NewController constructs the central controller state
 func NewController(
 	queue workqueue.RateLimitingInterface,
-	indexer cache.Indexer,
-	informer cache.Controller,
+	indexers map[string]cache.Indexer,
+	informers []cache.Controller,
 	clientset kubernetes.Interface,
 	mutex *sync.Mutex,
 	state map[string]DeploymentInfo,
 	dedup map[string]string,
+	store StateStore,
 	debug bool,
 	collectors *Collectors) *Controller {
-	return &Controller{
-		Informer:   informer,
-		Indexer:    indexer,
+	controller := &Controller{
+		Informers:  informers,
+		Indexers:   indexers,
 		Queue:      queue,
 		Clientset:  clientset,
 		Mutex:      mutex,
 		State:      state,
 		Dedup:      dedup,
+		Incidents:  map[string]IncidentInfo{},
+		Store:      store,
 		Debug:      debug,
 		Collectors: collectors,
+
+		MaxCycleTimeSeconds:      defaultMaxCycleTimeSeconds,
+		MaxTimeToRecoverySeconds: defaultMaxTimeToRecoverySeconds,
+		PersistInterval:          defaultPersistInterval,
+
+		persistPending: make(chan struct{}, 1),
+	}
+
+	// restore state saved by a previous controller instance
+	controller.reloadState()
+
+	return controller
+}
+
+// reloadState replaces State, Dedup and Incidents with the content of the
+// state store, dropping whatever a follower kept from before the last leader
+// saved its state
+func (c *Controller) reloadState() {
+	if c.Store == nil {
+		return
+	}
+	saved, err := c.Store.Load()
+	if err != nil {
+		log.Println(fmt.Sprintf("%s: can't load saved state: %v", au.Bold(au.Red("Error")), err))
+		return
+	}
+
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+	c.State = saved.State
+	c.Dedup = saved.Dedup
+	c.Incidents = saved.Incidents
+	log.Println(fmt.Sprintf("%s: restored state for %d deployments and %d open incidents", au.Bold(au.Cyan("INFO")), len(saved.State), len(saved.Incidents)))
+}
+
+// persistState schedules a write of State, Dedup and Incidents to the state
+// store; callers hold c.Mutex. The write happens outside the lock, in
+// runStatePersistence, so changes made in quick succession are saved together.
+func (c *Controller) persistState() {
+	if c.Store == nil {
+		return
+	}
+	select {
+	case c.persistPending <- struct{}{}:
+	default:
+		// a write is already pending
+	}
+}
+
+// runStatePersistence saves state when persistState asks for it, at most once
+// per PersistInterval, until stopCh is closed; pending changes are saved then
+func (c *Controller) runStatePersistence(stopCh <-chan struct{}) {
+	if c.Store == nil {
+		return
+	}
+	for {
+		select {
+		case <-c.persistPending:
+			c.saveState()
+		case <-stopCh:
+			select {
+			case <-c.persistPending:
+				c.saveState()
+			default:
+			}
+			return
+		}
+		select {
+		case <-time.After(c.PersistInterval):
+		case <-stopCh:
+		}
+	}
+}
+
+// saveState writes a snapshot of State, Dedup and Incidents to the state
+// store; a failed write is retried after PersistInterval
+func (c *Controller) saveState() {
+	c.Mutex.Lock()
+	c.pruneState(time.Now().Unix())
+	snapshot := emptyState()
+	for key, info := range c.State {
+		snapshot.State[key] = info
+	}
+	for key, reportBefore := range c.Dedup {
+		snapshot.Dedup[key] = reportBefore
+	}
+	for key, incident := range c.Incidents {
+		snapshot.Incidents[key] = incident
+	}
+	c.Mutex.Unlock()
+
+	err := c.Store.Save(snapshot)
+	if err != nil {
+		log.Println(fmt.Sprintf("%s: can't save state: %v", au.Bold(au.Red("Error")), err))
+		c.Mutex.Lock()
+		c.persistState()
+		c.Mutex.Unlock()
+	}
+}
+
+// pruneState drops Dedup entries of workloads without state, and incidents
+// open for longer than maxIncidentAge, which were never going to be resolved;
+// callers hold c.Mutex
+func (c *Controller) pruneState(now int64) {
+	for key := range c.Dedup {
+		if _, ok := c.State[dedupWorkloadKey(key)]; !ok {
+			delete(c.Dedup, key)
+		}
+	}
+	for key, incident := range c.Incidents {
+		if now-incident.Start > int64(maxIncidentAge.Seconds()) {
+			log.Println(fmt.Sprintf("%s: dropping incident %s, open since %d", au.Bold(au.Cyan("INFO")), key, incident.Start))
+			delete(c.Incidents, key)
+		}
 	}
 }
 
@@ -66,7 +192,19 @@ func (c *Controller) processNextItem() bool {
 }
 
 func (c *Controller) syncToStdout(key string) error {
-	obj, keyExists, err := c.Indexer.GetByKey(key)
+	kind, metaKey, err := splitWorkloadKey(key)
+	if err != nil {
+		log.Println(fmt.Sprintf("%s: %v", au.Bold(au.Red("Error")), err))
+		return nil
+	}
+
+	indexer, ok := c.Indexers[kind]
+	if !ok {
+		log.Println(fmt.Sprintf("%s: no informer for kind %s", au.Bold(au.Red("Error")), kind))
+		return nil
+	}
+
+	obj, keyExists, err := indexer.GetByKey(metaKey)
 	if err != nil {
 		log.Println(fmt.Sprintf("%s: fetching object with key %s from store failed with %v",
 			au.Bold(au.Red("Error")),
@@ -75,54 +213,106 @@ func (c *Controller) syncToStdout(key string) error {
 		return err
 	}
 
-	// exit condition 1: nil interface received (e.g. after manual resource deletion)
-	// nothing to do here; return gracefully
-	if obj == nil {
+	// exit condition 1: the workload has been deleted, possibly while we
+	// weren't watching (informers deliver those as tombstones); the key is
+	// all that's left of it
+	if !keyExists {
+		namespace, name, err := cache.SplitMetaNamespaceKey(metaKey)
+		if err != nil {
+			log.Println(fmt.Sprintf("%s: %v", au.Bold(au.Red("Error")), err))
+			return nil
+		}
+		c.workloadDeleted(key, kind, namespace, name)
 		return nil
 	}
 
-	name := obj.(*appsv1.Deployment).GetName()
-	namespace := obj.(*appsv1.Deployment).ObjectMeta.Namespace
-	replicas := obj.(*appsv1.Deployment).Spec.Replicas             // *int32
-	readyReplicas := obj.(*appsv1.Deployment).Status.ReadyReplicas // int32
+	workload, err := asWorkload(obj)
+	if err != nil {
+		log.Println(fmt.Sprintf("%s: %v", au.Bold(au.Red("Error")), err))
+		return nil
+	}
 
-	// exit condition 2: deployment has been deleted
-	if !keyExists {
-		log.Println(fmt.Sprintf("%s: deployment %s in namespace %s has been deleted",
-			au.Bold(au.Cyan("INFO")),
-			name,
-			namespace))
+	name := workload.GetName()
+	namespace := workload.GetNamespace()
+	replicas := workload.DesiredReplicas()
+	readyReplicas := workload.ReadyReplicas()
+
+	// the queue key identifies the workload across kinds; we'll use it more than once
+	lookupKey := key
+
+	// before we take the lock for the rest: listing pods may take a while
+	podsRunning := c.verifyReportedSuccess(lookupKey, workload, time.Now().Unix())
+
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+
+	// exit condition 3: neither labelled nor configured as a target; a
+	// workload with state was tracked until a reload removed its target
+	if !c.tracked(kind, namespace, name, workload.GetLabels()) {
+		_, known := c.State[lookupKey]
+		if _, ok := c.Dedup[lookupKey]; known || ok {
+			deleted := c.forgetWorkload(lookupKey, kind, namespace, name)
+			log.Println(fmt.Sprintf("%s: removed %d metric series and the state of %s %s in namespace %s, which is no longer tracked", au.Bold(au.Cyan("INFO")), deleted, kind, au.Bold(name), au.Bold(namespace)))
+		}
 		return nil
 	}
+	metricLabels := c.metricLabels(name, namespace, kind)
 
-	// create single-string lookup key; we'll use it more than once
-	lookupKey := namespace + name
+	// write state through to the store if anything changed
+	stateChanged := false
+	defer func() {
+		if stateChanged {
+			c.persistState()
+		}
+	}()
 
 	if c.Debug {
-		log.Println(fmt.Sprintf("%s: processing deployment %s", au.Bold(au.Cyan("INFO")), au.Bold(name)))
+		log.Println(fmt.Sprintf("%s: processing %s %s", au.Bold(au.Cyan("INFO")), kind, au.Bold(name)))
 	}
 
 	// keep in mind annotation values are all strings, even '100' and 'true'
-	reportBeforeAnnotation := obj.(*appsv1.Deployment).ObjectMeta.Annotations[fmt.Sprintf("%s/%s", annotationPrefix, annotationNameReportBefore)]
-	cycleTimeAnnotation := obj.(*appsv1.Deployment).ObjectMeta.Annotations[fmt.Sprintf("%s/%s", annotationPrefix, annotationNameCycleTime)]
-	successAnnotation := obj.(*appsv1.Deployment).ObjectMeta.Annotations[fmt.Sprintf("%s/%s", annotationPrefix, annotationNameSuccess)]
+	annotations := workload.GetAnnotations()
+	reportBeforeAnnotation := annotations[fmt.Sprintf("%s/%s", annotationPrefix, annotationNameReportBefore)]
+	cycleTimeAnnotation := annotations[fmt.Sprintf("%s/%s", annotationPrefix, annotationNameCycleTime)]
+	successAnnotation := annotations[fmt.Sprintf("%s/%s", annotationPrefix, annotationNameSuccess)]
+
+	// recreated before its deletion took effect
+	if info, ok := c.State[lookupKey]; ok && info.DeletedAt > 0 {
+		info.DeletedAt = 0
+		c.State[lookupKey] = info
+		stateChanged = true
+	}
+
+	if _, ok := c.State[lookupKey]; !ok {
+		c.State[lookupKey] = DeploymentInfo{
+			Name:          name,
+			Namespace:     namespace,
+			Kind:          kind,
+			Replicas:      replicas,
+			ReadyReplicas: readyReplicas,
+			ErrorStart:    0, // flag no error on creation
+		}
+		stateChanged = true
+	}
 
 	// deduplication: ignore annotations if we've already seen this update
-	processAnnotations := true
+	// workloads that have never been annotated are only tracked for outages
+	processAnnotations := len(reportBeforeAnnotation) > 0
 	if _, ok := c.Dedup[lookupKey]; ok {
 		if c.Dedup[lookupKey] == reportBeforeAnnotation {
 			processAnnotations = false
 		}
 	}
-	c.Dedup[lookupKey] = reportBeforeAnnotation
 
 	now := time.Now()
 	unixTimeSeconds := now.Unix()
 
 	if processAnnotations {
+		stateChanged = true
 		// we don't measure lead time for failed deployments
 		reportBeforeSeconds, err := strconv.Atoi(reportBeforeAnnotation)
 		if err != nil {
+			c.Dedup[lookupKey] = reportBeforeAnnotation
 			log.Println(fmt.Sprintf(
 				"%s: cannot parse annotation %s/%s=%s: %v",
 				au.Bold(au.Red("Error")),
@@ -132,79 +322,77 @@ func (c *Controller) syncToStdout(key string) error {
 				err))
 			return err
 		}
+		// cycle time must be a positive integer; anything else is ignored
+		cycleTimeSeconds, _ := strconv.Atoi(cycleTimeAnnotation)
+		event := DeploymentEvent{
+			Service:          name,
+			Namespace:        namespace,
+			Kind:             kind,
+			Success:          successAnnotation == "true",
+			CycleTimeSeconds: int64(cycleTimeSeconds),
+			CommitSHA:        annotations[fmt.Sprintf("%s/%s", annotationPrefix, annotationNameCommitSHA)],
+			CommitTimestamps: parseTimestamps(
+				annotations[fmt.Sprintf("%s/%s", annotationPrefix, annotationNameCommitTimestamp)],
+				annotations[fmt.Sprintf("%s/%s", annotationPrefix, annotationNameCommitTimestamps)]),
+		}
+		info := c.State[lookupKey]
 		if int64(reportBeforeSeconds) > unixTimeSeconds {
-			if successAnnotation == "true" {
-				// cycle time must be a positive integer
-				cycleTimeSeconds, err := strconv.Atoi(cycleTimeAnnotation)
-				if err == nil && cycleTimeSeconds > 0 {
-					if cycleTimeSeconds > maxCycleTimeSeconds {
-						cycleTimeSeconds = maxCycleTimeSeconds
-					}
-					log.Println(fmt.Sprintf("%s: submitting cycle time %d for deployment %s in namespace %s", au.Bold(au.Cyan("INFO")), au.Bold(cycleTimeSeconds), au.Bold(name), au.Bold(namespace)))
-					c.Collectors.CycleTimeGauge.With(prometheus.Labels{"deployment": name, "namespace": namespace}).Set(float64(cycleTimeSeconds))
+			if event.Success && !podsRunning {
+				// check again until report-before; the report isn't recorded as seen yet
+				if info.VerifyPending != reportBeforeAnnotation {
+					log.Println(fmt.Sprintf("%s: waiting for pods of %s %s in namespace %s to confirm successful deployment", au.Bold(au.Cyan("INFO")), kind, au.Bold(name), au.Bold(namespace)))
+					info.VerifyPending = reportBeforeAnnotation
+					c.State[lookupKey] = info
+				}
+				retry := time.Duration(int64(reportBeforeSeconds)-unixTimeSeconds) * time.Second
+				if retry > verifyRetryInterval {
+					retry = verifyRetryInterval
 				}
-				// report success
-				c.Collectors.SuccessCounter.With(prometheus.Labels{"deployment": name, "namespace": namespace}).Inc()
+				c.Queue.AddAfter(key, retry)
 			} else {
-				log.Println(fmt.Sprintf("%s: reporting failed deployment for deployment %s in namespace %s", au.Bold(au.Cyan("INFO")), au.Bold(name), au.Bold(namespace)))
-				c.Collectors.FailureCounter.With(prometheus.Labels{"deployment": name, "namespace": namespace}).Inc()
+				c.Dedup[lookupKey] = reportBeforeAnnotation
+				info.VerifyPending = ""
+				c.State[lookupKey] = info
+				c.reportDeployment(event)
 			}
 		} else {
-			if c.Debug {
+			c.Dedup[lookupKey] = reportBeforeAnnotation
+			if info.VerifyPending == reportBeforeAnnotation {
+				log.Println(fmt.Sprintf("%s: pods of %s %s in namespace %s never confirmed the reported success; counting a failed deployment", au.Bold(au.Red("Error")), kind, au.Bold(name), au.Bold(namespace)))
+				info.VerifyPending = ""
+				c.State[lookupKey] = info
+				event.Success = false
+				c.reportDeployment(event)
+			} else if c.Debug {
 				log.Println(fmt.Sprintf("%s: ignoring stale annotations for deployment %s in namespace %s", au.Bold(au.Cyan("INFO")), au.Bold(name), au.Bold(namespace)))
 			}
 		}
 	}
 
-	if _, ok := c.State[lookupKey]; !ok {
-		c.State[lookupKey] = DeploymentInfo{
-			name,
-			namespace,
-			*replicas,
-			readyReplicas,
-			0, // flag no error on creation
+	if c.autoDetectEnabled(kind, namespace, name, annotations) {
+		if c.detectRollout(lookupKey, workload, unixTimeSeconds) {
+			stateChanged = true
 		}
 	}
 
-	var errorStart int64
-	errorStart = 0
-
-	if (*replicas) > 0 && readyReplicas == 0 {
-		// failed state
-		// set errorStart unless already set
-		if c.State[lookupKey].ErrorStart == 0 {
-			log.Println(fmt.Sprintf("%s: entered error state for deployment %s in namespace %s", au.Bold(au.Cyan("INFO")), au.Bold(name), au.Bold(namespace)))
-
-			errorStart = unixTimeSeconds
-			info := c.State[lookupKey]
-			info.ErrorStart = errorStart
-			c.State[lookupKey] = info
-			c.Collectors.DowntimeCounter.With(prometheus.Labels{"deployment": name, "namespace": namespace}).Inc()
-		}
-	} else if (*replicas) == readyReplicas {
-		// non-failed state (may still be an impaired deployment)
-		// we ignore these for the purposes of DORA reporting
-		// set TTR if ErrorStart > 0
-		// then reset errorStart to 0
-		if c.State[lookupKey].ErrorStart > 0 {
-			timeToRecovery := unixTimeSeconds - c.State[lookupKey].ErrorStart
-			if timeToRecovery > maxTimeToRecoverySeconds {
-				timeToRecovery = maxTimeToRecoverySeconds
-			}
-			log.Println(fmt.Sprintf("%s: left error state for deployment %s in namespace %s: TTR was %d", au.Bold(au.Cyan("INFO")), au.Bold(name), au.Bold(namespace), au.Bold(timeToRecovery)))
-			c.Collectors.TimeToRecoveryGauge.With(prometheus.Labels{"deployment": name, "namespace": namespace}).Set(math.Round(float64(timeToRecovery)))
-			info := c.State[lookupKey]
-			info.ErrorStart = 0
-			c.State[lookupKey] = info
-		}
+	threshold, err := c.availabilityThreshold(kind, namespace, name, annotations)
+	if err != nil {
+		log.Println(fmt.Sprintf("%s: %s %s in namespace %s: %v", au.Bold(au.Red("Error")), kind, au.Bold(name), au.Bold(namespace), err))
+		threshold = c.DefaultAvailabilityThreshold
+	}
+	state := availabilityState(replicas, readyReplicas, threshold)
+	if c.trackOutage(key, workload, state, metricLabels, unixTimeSeconds) {
+		stateChanged = true
 	}
+	errorStart := c.State[lookupKey].ErrorStart
 
 	deployment := DeploymentInfo{
-		name,
-		namespace,
-		*replicas,
-		readyReplicas,
-		errorStart,
+		Name:          name,
+		Namespace:     namespace,
+		Kind:          kind,
+		Replicas:      replicas,
+		ReadyReplicas: readyReplicas,
+		ErrorStart:    errorStart,
 	}
 
 	if c.Debug {
@@ -227,6 +415,55 @@ func (c *Controller) syncToStdout(key string) error {
 	return nil
 }
 
+// verifyReportedSuccess confirms a success reported in the workload's
+// annotations against its pods, if we haven't recorded the report yet and
+// it's still due. It holds c.Mutex only to check that, not while listing the
+// pods, so the API server doesn't hold up other workers and the HTTP
+// handlers; syncToStdout checks again under the lock before recording it.
+func (c *Controller) verifyReportedSuccess(key string, workload Workload, now int64) bool {
+	annotations := workload.GetAnnotations()
+	reportBeforeAnnotation := annotations[fmt.Sprintf("%s/%s", annotationPrefix, annotationNameReportBefore)]
+	if annotations[fmt.Sprintf("%s/%s", annotationPrefix, annotationNameSuccess)] != "true" {
+		return false
+	}
+	if reportBeforeSeconds, err := strconv.ParseInt(reportBeforeAnnotation, 10, 64); err != nil || reportBeforeSeconds <= now {
+		return false
+	}
+
+	c.Mutex.Lock()
+	pending := c.tracked(workload.Kind(), workload.GetNamespace(), workload.GetName(), workload.GetLabels()) &&
+		c.Dedup[key] != reportBeforeAnnotation
+	c.Mutex.Unlock()
+	if !pending {
+		return false
+	}
+	return c.confirmPodsRunning(workload)
+}
+
+// confirmPodsRunning verifies a reported success against the workload's pods
+func (c *Controller) confirmPodsRunning(workload Workload) bool {
+	running, err := verifyPodsRunning(c.Clientset, workload)
+	if err != nil {
+		log.Println(fmt.Sprintf("%s: can't verify pods of %s %s in namespace %s: %v", au.Bold(au.Red("Error")), workload.Kind(), au.Bold(workload.GetName()), au.Bold(workload.GetNamespace()), err))
+		return false
+	}
+	return running
+}
+
+// parseTimestamps reads comma-separated unix timestamps, skipping anything unparseable
+func parseTimestamps(lists ...string) []int64 {
+	var timestamps []int64
+	for _, list := range lists {
+		for _, item := range strings.Split(list, ",") {
+			timestamp, err := strconv.ParseInt(strings.TrimSpace(item), 10, 64)
+			if err == nil && timestamp > 0 {
+				timestamps = append(timestamps, timestamp)
+			}
+		}
+	}
+	return timestamps
+}
+
 // handleErr checks if an error happened and makes sure we will retry later.
 func (c *Controller) handleErr(err error, key interface{}) {
 	if err == nil {
@@ -252,19 +489,69 @@ func (c *Controller) Run(threadiness int, stopCh chan struct{}) {
 	defer c.Queue.ShutDown()
 	log.Println(fmt.Sprintf("%s: starting DORA controller", au.Bold(au.Cyan("INFO"))))
 
-	go c.Informer.Run(stopCh)
+	c.runInformers(stopCh)
+
+	c.Lead(threadiness, stopCh)
+	log.Println(fmt.Sprintf("%s: stopping DORA controller", au.Bold(au.Cyan("INFO"))))
+}
+
+// RunFollower keeps the informer cache warm without processing the workqueue;
+// workers are started by Lead once leadership has been acquired
+func (c *Controller) RunFollower(stopCh <-chan struct{}) {
+	defer runtime.HandleCrash()
+
+	log.Println(fmt.Sprintf("%s: starting DORA controller as follower", au.Bold(au.Cyan("INFO"))))
+	c.Collectors.LeaderGauge.Set(0)
+	c.runInformers(stopCh)
+}
+
+func (c *Controller) runInformers(stopCh <-chan struct{}) {
+	for _, informer := range c.Informers {
+		go informer.Run(stopCh)
+	}
+}
+
+func (c *Controller) hasSynced() bool {
+	for _, informer := range c.Informers {
+		if !informer.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
 
-	if !cache.WaitForCacheSync(stopCh, c.Informer.HasSynced) {
+// Lead processes the workqueue until stopCh is closed
+func (c *Controller) Lead(threadiness int, stopCh <-chan struct{}) {
+	if !cache.WaitForCacheSync(stopCh, c.hasSynced) {
 		runtime.HandleError(fmt.Errorf("Timed out waiting for caches to sync"))
 		return
 	}
 
+	c.Collectors.LeaderGauge.Set(1)
+	atomic.StoreInt32(&c.leading, 1)
+	defer c.Collectors.LeaderGauge.Set(0)
+	defer atomic.StoreInt32(&c.leading, 0)
+
+	// state may name workloads that were deleted while no leader was watching
+	c.queueVanished()
+
+	// only the leader writes state and pages
+	go c.runStatePersistence(stopCh)
+	if c.Alerter != nil {
+		go c.Alerter.Run(stopCh)
+	}
+
 	for i := 0; i < threadiness; i++ {
 		go wait.Until(c.runWorker, time.Second, stopCh)
 	}
 
 	<-stopCh
-	log.Println(fmt.Sprintf("%s: stopping DORA controller", au.Bold(au.Cyan("INFO"))))
+}
+
+// IsLeader tells whether the controller processes the workqueue and may
+// change state, which is never the case for followers
+func (c *Controller) IsLeader() bool {
+	return atomic.LoadInt32(&c.leading) == 1
 }
 
 func (c *Controller) runWorker() {
@@ -0,0 +1,297 @@
+package dorametrics
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// newTestController returns a controller with unregistered collectors and an
+// indexer per kind that tests fill directly
+func newTestController(t *testing.T) *Controller {
+	collectors := Collectors{}
+	err := RegisterCollectors(&collectors, CollectorOptions{}, true)
+	if err != nil {
+		t.Fatalf("Can't register collectors: %v", err)
+	}
+	indexers := map[string]cache.Indexer{}
+	for _, kind := range []string{KindDeployment, KindStatefulSet, KindDaemonSet, KindRollout} {
+		indexers[kind] = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	}
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	t.Cleanup(queue.ShutDown)
+	return NewController(
+		queue,
+		indexers,
+		nil,
+		fake.NewSimpleClientset(),
+		&sync.Mutex{},
+		map[string]DeploymentInfo{},
+		map[string]string{},
+		nil,
+		false,
+		&collectors)
+}
+
+func deployment(name string, replicas int32, readyReplicas int32, annotations map[string]string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: enabledLabels, Annotations: annotations},
+		Spec:       appsv1.DeploymentSpec{Replicas: int32Ptr(replicas)},
+		Status:     appsv1.DeploymentStatus{ReadyReplicas: readyReplicas},
+	}
+}
+
+func reportAnnotations(success bool, cycleTime int) map[string]string {
+	return map[string]string{
+		"dora-controller/report-before": strconv.FormatInt(time.Now().Unix()+600, 10),
+		"dora-controller/success":       fmt.Sprintf("%t", success),
+		"dora-controller/cycle-time":    strconv.Itoa(cycleTime),
+	}
+}
+
+func TestSyncToStdout(t *testing.T) {
+	c := newTestController(t)
+	labels := c.metricLabels("server-a", "default", KindDeployment)
+	key := "Deployment/default/server-a"
+
+	// successful deployment reported through annotations
+	annotations := reportAnnotations(true, 125)
+	d := verifiedDeployment("server-a", 2, annotations)
+	c.Clientset = fake.NewSimpleClientset(runningPods(d)...)
+	c.Indexers[KindDeployment].Add(d)
+	if err := c.syncToStdout(key); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// a second update with the same annotations must not count twice
+	if err := c.syncToStdout(key); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if value := testutil.ToFloat64(c.Collectors.SuccessCounter.With(labels)); value != 1 {
+		t.Errorf("Unexpected success count %v; expected 1", value)
+	}
+	if value := testutil.ToFloat64(c.Collectors.CycleTimeGauge.With(labels)); value != 125 {
+		t.Errorf("Unexpected cycle time %v; expected 125", value)
+	}
+
+	// outage
+	c.Indexers[KindDeployment].Update(deployment("server-a", 2, 0, annotations))
+	if err := c.syncToStdout(key); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if value := testutil.ToFloat64(c.Collectors.DowntimeCounter.With(withSource(withState(labels, availabilityDown), recoverySourcePods))); value != 1 {
+		t.Errorf("Unexpected downtime count %v; expected 1", value)
+	}
+	if c.State[key].ErrorStart == 0 {
+		t.Errorf("Expected error state to be recorded")
+	}
+
+	// recovery
+	c.Indexers[KindDeployment].Update(deployment("server-a", 2, 2, annotations))
+	if err := c.syncToStdout(key); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if c.State[key].ErrorStart != 0 {
+		t.Errorf("Expected error state to be cleared")
+	}
+	if count := testutil.CollectAndCount(&c.Collectors.TimeToRecoveryHistogram); count != 1 {
+		t.Errorf("Unexpected number of time to recovery series %d; expected 1", count)
+	}
+}
+
+// sampleCount returns the number of observations in a histogram
+func sampleCount(t *testing.T, observer prometheus.Observer) uint64 {
+	metric := &dto.Metric{}
+	if err := observer.(prometheus.Metric).Write(metric); err != nil {
+		t.Fatalf("Can't read histogram: %v", err)
+	}
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestLeadTime(t *testing.T) {
+	now := time.Now().Unix()
+
+	var tests = []struct {
+		description string
+		success     bool
+		single      string
+		batch       string
+		expected    uint64
+	}{
+		{"single_commit", true, strconv.FormatInt(now-3600, 10), "", 1},
+		{"batched_release", true, strconv.FormatInt(now-3600, 10), fmt.Sprintf("%d, %d", now-7200, now-86400), 3},
+		{"future_commit_ignored", true, strconv.FormatInt(now+3600, 10), "", 0},
+		{"failed_deployment", false, strconv.FormatInt(now-3600, 10), "", 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			c := newTestController(t)
+			annotations := reportAnnotations(test.success, 125)
+			annotations["dora-controller/commit-sha"] = "4f2a9c1"
+			annotations["dora-controller/commit-timestamp"] = test.single
+			annotations["dora-controller/commit-timestamps"] = test.batch
+			d := verifiedDeployment("server-a", 1, annotations)
+			c.Clientset = fake.NewSimpleClientset(runningPods(d)...)
+			c.Indexers[KindDeployment].Add(d)
+			if err := c.syncToStdout("Deployment/default/server-a"); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			labels := c.metricLabels("server-a", "default", KindDeployment)
+			if count := sampleCount(t, c.Collectors.LeadTimeHistogram.With(labels)); count != test.expected {
+				t.Errorf("Unexpected number of lead time observations %d; expected %d", count, test.expected)
+			}
+		})
+	}
+}
+
+func TestDeploymentEvents(t *testing.T) {
+	c := newTestController(t)
+	labels := c.metricLabels("server-a", "default", KindDeployment)
+	eventCount := func(outcome string, imageChange string, transition string) float64 {
+		eventLabels := prometheus.Labels{"outcome": outcome, "image_change": imageChange, "transition": transition}
+		for name, value := range labels {
+			eventLabels[name] = value
+		}
+		return testutil.ToFloat64(c.Collectors.DeploymentEventCounter.With(eventLabels))
+	}
+	report := func(image string, success bool) {
+		d := deployment("server-a", 1, 1, nil)
+		d.Spec.Template.Spec.Containers = []corev1.Container{{Name: "server", Image: image}}
+		c.Indexers[KindDeployment].Add(d)
+		c.reportDeployment(DeploymentEvent{Service: "server-a", Namespace: "default", Kind: KindDeployment, Success: success})
+	}
+
+	report("server:1", true)
+	report("server:2", false)
+	report("server:2", false)
+	report("server:2", true)
+
+	var tests = []struct {
+		outcome     string
+		imageChange string
+		transition  string
+		count       float64
+	}{
+		{"success", "true", "none", 1},
+		{"failure", "true", "none", 1},
+		{"failure", "false", "repeat_failure", 1},
+		{"success", "false", "recovery", 1},
+		{"success", "false", "none", 0},
+	}
+	for _, test := range tests {
+		if count := eventCount(test.outcome, test.imageChange, test.transition); count != test.count {
+			t.Errorf("Unexpected count %v for outcome=%s, image_change=%s, transition=%s; expected %v", count, test.outcome, test.imageChange, test.transition, test.count)
+		}
+	}
+	if c.State["Deployment/default/server-a"].Images != "server:2" {
+		t.Errorf("Unexpected images '%s'; expected 'server:2'", c.State["Deployment/default/server-a"].Images)
+	}
+}
+
+func TestConfirmReportedSuccess(t *testing.T) {
+	c := newTestController(t)
+	labels := c.metricLabels("server-a", "default", KindDeployment)
+	key := "Deployment/default/server-a"
+	sync := func() {
+		if err := c.syncToStdout(key); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// no pods yet: success is held back
+	d := verifiedDeployment("server-a", 1, reportAnnotations(true, 125))
+	c.Indexers[KindDeployment].Add(d)
+	sync()
+	if value := testutil.ToFloat64(c.Collectors.SuccessCounter.With(labels)); value != 0 {
+		t.Errorf("Unexpected success count %v before pods are running; expected 0", value)
+	}
+	if _, ok := c.Dedup[key]; ok {
+		t.Errorf("Expected report to remain unseen until confirmed")
+	}
+
+	// pods running: success is counted
+	c.Clientset = fake.NewSimpleClientset(runningPods(d)...)
+	sync()
+	sync()
+	if value := testutil.ToFloat64(c.Collectors.SuccessCounter.With(labels)); value != 1 {
+		t.Errorf("Unexpected success count %v; expected 1", value)
+	}
+
+	// pods not running the new image: the report stays pending
+	annotations := reportAnnotations(true, 125)
+	annotations["dora-controller/report-before"] = strconv.FormatInt(time.Now().Unix()+1200, 10)
+	d = verifiedDeployment("server-a", 1, annotations)
+	d.Spec.Template.Spec.Containers[0].Image = "debian"
+	c.Indexers[KindDeployment].Update(d)
+	sync()
+	if c.State[key].VerifyPending != annotations["dora-controller/report-before"] {
+		t.Fatalf("Expected verification to be pending; got %+v", c.State[key])
+	}
+
+	// report-before passes without confirmation: counted as a failure
+	reportBefore := strconv.FormatInt(time.Now().Unix()-1, 10)
+	info := c.State[key]
+	info.VerifyPending = reportBefore
+	c.State[key] = info
+	annotations["dora-controller/report-before"] = reportBefore
+	c.Indexers[KindDeployment].Update(verifiedDeployment("server-a", 1, annotations))
+	sync()
+	if value := testutil.ToFloat64(c.Collectors.FailureCounter.With(labels)); value != 1 {
+		t.Errorf("Unexpected failure count %v; expected 1", value)
+	}
+	if value := testutil.ToFloat64(c.Collectors.SuccessCounter.With(labels)); value != 1 {
+		t.Errorf("Unexpected success count %v; expected 1", value)
+	}
+}
+
+func TestVerifyWithoutLock(t *testing.T) {
+	c := newTestController(t)
+	d := verifiedDeployment("server-a", 1, reportAnnotations(true, 125))
+	c.Indexers[KindDeployment].Add(d)
+	clientset := fake.NewSimpleClientset(runningPods(d)...)
+	c.Clientset = clientset
+
+	// handlers must be able to take the lock while pods are listed
+	lockFree := false
+	clientset.PrependReactor("list", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		locked := make(chan struct{})
+		go func() {
+			c.Mutex.Lock()
+			c.Mutex.Unlock()
+			close(locked)
+		}()
+		select {
+		case <-locked:
+			lockFree = true
+		case <-time.After(time.Second):
+		}
+		return false, nil, nil
+	})
+
+	if err := c.syncToStdout("Deployment/default/server-a"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !lockFree {
+		t.Errorf("Expected the lock to be free while listing pods")
+	}
+	labels := c.metricLabels("server-a", "default", KindDeployment)
+	if value := testutil.ToFloat64(c.Collectors.SuccessCounter.With(labels)); value != 1 {
+		t.Errorf("Unexpected success count %v; expected 1", value)
+	}
+}
@@ -2,47 +2,251 @@ package dorametrics
 
 import (
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
 	"net/http"
+	"sync"
+	"time"
+
+	au "github.com/logrusorgru/aurora"
 )
 
-const pagerDutyUrl = "https://api.pagerduty.com/incidents"
+const pagerDutyUrl = "https://events.pagerduty.com/v2/enqueue"
+const alertDedupPrefix = "dora-metrics/"
+const alertAttempts = 5
+const defaultAlertBackoff = time.Second
+const maxAlertBackoff = 30 * time.Second
+
+// pagerDutyEvent is an Events API v2 request
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Component     string            `json:"component"`
+	Group         string            `json:"group"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+// Alerter opens and resolves PagerDuty incidents through the Events API v2.
+// Events are queued and sent in order by Run, so that callers holding the
+// controller's lock never wait for PagerDuty.
+type Alerter struct {
+	URL        string
+	RoutingKey string
+	Client     *http.Client
+	Backoff    time.Duration // wait before the first retry, doubled for every further one
+
+	mutex   sync.Mutex
+	pending []pagerDutyEvent
+	wake    chan struct{}
+}
+
+// NewAlerter returns an Alerter for the given routing key; url defaults to PagerDuty's endpoint
+func NewAlerter(url string, routingKey string) *Alerter {
+	if len(url) == 0 {
+		url = pagerDutyUrl
+	}
+	// not http.DefaultTransport, which skips verification for the API server
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	return &Alerter{
+		URL:        url,
+		RoutingKey: routingKey,
+		Client:     &http.Client{Timeout: 10 * time.Second, Transport: transport},
+		Backoff:    defaultAlertBackoff,
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// alertDedupKey ties trigger and resolve events for the same workload
+// together; it must not depend on anything a reload can change
+func alertDedupKey(kind string, namespace string, name string) string {
+	return fmt.Sprintf("%s%s/%s/%s", alertDedupPrefix, kind, namespace, name)
+}
 
-type Payload struct {
-	Incident Incident `json:"incident"`
+func (a *Alerter) createAlert(kind string, namespace string, name string, state string, stage string) pagerDutyEvent {
+	return pagerDutyEvent{
+		RoutingKey:  a.RoutingKey,
+		EventAction: "trigger",
+		DedupKey:    alertDedupKey(kind, namespace, name),
+		Payload: &pagerDutyPayload{
+			Summary:   fmt.Sprintf("%s %s in namespace %s is %s (stage %s)", kind, name, namespace, state, stage),
+			Source:    "dora-metrics",
+			Severity:  "critical",
+			Component: name,
+			Group:     namespace,
+			CustomDetails: map[string]string{
+				"kind":      kind,
+				"namespace": namespace,
+				"name":      name,
+				"state":     state,
+				"stage":     stage,
+			},
+		},
+	}
 }
-type Service struct {
-	ID   string `json:"id"`
-	Type string `json:"type"`
+
+func (a *Alerter) resolveAlert(kind string, namespace string, name string) pagerDutyEvent {
+	return pagerDutyEvent{
+		RoutingKey:  a.RoutingKey,
+		EventAction: "resolve",
+		DedupKey:    alertDedupKey(kind, namespace, name),
+	}
 }
-type Incident struct {
-	Type    string  `json:"type"`
-	Title   string  `json:"title"`
-	Service Service `json:"service"`
+
+// enqueue hands the event to Run without blocking. Nothing is dropped: an
+// event only replaces a pending one for the same workload and action, so a
+// repeated trigger is sent once.
+func (a *Alerter) enqueue(event pagerDutyEvent) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for i := len(a.pending) - 1; i >= 0; i-- {
+		if a.pending[i].DedupKey != event.DedupKey {
+			continue
+		}
+		if a.pending[i].EventAction == event.EventAction {
+			a.pending[i] = event
+			return
+		}
+		break
+	}
+	a.pending = append(a.pending, event)
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
 }
 
-func createAlert(service string, namespace string, stage string, username string, token string) error {
-	data := Payload{}
-	payloadBytes, err := json.Marshal(data)
+// requeue queues an event again unless a later one for the same workload is pending
+func (a *Alerter) requeue(event pagerDutyEvent) {
+	a.mutex.Lock()
+	for _, pending := range a.pending {
+		if pending.DedupKey == event.DedupKey {
+			a.mutex.Unlock()
+			return
+		}
+	}
+	a.mutex.Unlock()
+	a.enqueue(event)
+}
+
+// next takes the oldest pending event off the queue
+func (a *Alerter) next() (pagerDutyEvent, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if len(a.pending) == 0 {
+		return pagerDutyEvent{}, false
+	}
+	event := a.pending[0]
+	a.pending = a.pending[1:]
+	return event, true
+}
+
+// Run sends queued events until stopCh is closed. A resolve that can't be
+// delivered is queued again, as the incident would otherwise stay open.
+func (a *Alerter) Run(stopCh <-chan struct{}) {
+	for {
+		select {
+		case <-stopCh:
+			return
+		default:
+		}
+		event, ok := a.next()
+		if !ok {
+			select {
+			case <-a.wake:
+			case <-stopCh:
+				return
+			}
+			continue
+		}
+		retry, err := a.sendWithRetry(event, stopCh)
+		if err == nil {
+			continue
+		}
+		log.Println(fmt.Sprintf("%s: %v", au.Bold(au.Red("Error")), err))
+		if retry && event.EventAction == "resolve" {
+			a.requeue(event)
+		}
+	}
+}
+
+// sendWithRetry retries failed requests and server errors with exponential
+// backoff and, like send, tells whether a final failure is worth retrying
+func (a *Alerter) sendWithRetry(event pagerDutyEvent, stopCh <-chan struct{}) (bool, error) {
+	backoff := a.Backoff
+	for attempt := 1; ; attempt++ {
+		retry, err := a.send(event)
+		if err == nil || !retry || attempt == alertAttempts {
+			return retry, err
+		}
+		log.Println(fmt.Sprintf("%s: %v; retrying in %s", au.Bold(au.Red("Error")), err, backoff))
+		select {
+		case <-time.After(backoff):
+		case <-stopCh:
+			return retry, err
+		}
+		if backoff *= 2; backoff > maxAlertBackoff {
+			backoff = maxAlertBackoff
+		}
+	}
+}
+
+// send posts the event and tells whether a failure is worth retrying
+func (a *Alerter) send(event pagerDutyEvent) (bool, error) {
+	payloadBytes, err := json.Marshal(event)
 	if err != nil {
-		// TODO: handle error
+		return false, fmt.Errorf("can't marshal PagerDuty event: %v", err)
 	}
-	body := bytes.NewReader(payloadBytes)
 
-	req, err := http.NewRequest("POST", pagerDutyUrl, body)
+	req, err := http.NewRequest("POST", a.URL, bytes.NewReader(payloadBytes))
 	if err != nil {
-		// TODO: handle error
+		return false, fmt.Errorf("can't create PagerDuty request: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/vnd.pagerduty+json;version=2")
-	req.Header.Set("From", username)
-	req.Header.Set("Authorization", "Token token="+token)
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := a.Client.Do(req)
 	if err != nil {
-		// TODO: handle error
+		return true, fmt.Errorf("can't send PagerDuty event: %v", err)
 	}
 	defer resp.Body.Close()
 
-	return nil
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := ioutil.ReadAll(resp.Body)
+		// PagerDuty asks clients to retry throttled requests and server errors
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+		return retry, fmt.Errorf("PagerDuty rejected %s event with status %d: %s", event.EventAction, resp.StatusCode, body)
+	}
+	return false, nil
+}
+
+// alertOnTransition triggers a PagerDuty incident for configured targets
+// with alert: true when they enter the error state, or resolves it when
+// state is empty; callers hold c.Mutex, so the event is only queued
+func (c *Controller) alertOnTransition(kind string, namespace string, name string, state string) {
+	if c.Alerter == nil || c.Config == nil {
+		return
+	}
+	target, ok := c.Config.findTarget(kind, namespace, name)
+	if !ok || !target.Alert {
+		return
+	}
+
+	if len(state) > 0 {
+		log.Println(fmt.Sprintf("%s: triggering PagerDuty incident for %s %s in namespace %s", au.Bold(au.Cyan("INFO")), kind, au.Bold(name), au.Bold(namespace)))
+		c.Alerter.enqueue(c.Alerter.createAlert(kind, namespace, name, state, c.Config.Stage))
+	} else {
+		log.Println(fmt.Sprintf("%s: resolving PagerDuty incident for %s %s in namespace %s", au.Bold(au.Cyan("INFO")), kind, au.Bold(name), au.Bold(namespace)))
+		c.Alerter.enqueue(c.Alerter.resolveAlert(kind, namespace, name))
+	}
 }
@@ -0,0 +1,187 @@
+package dorametrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// pagerDutyServer records the events it accepts; it answers the first
+// failures requests with a server error
+func pagerDutyServer(failures int) (*httptest.Server, func() []pagerDutyEvent) {
+	var mutex sync.Mutex
+	var received []pagerDutyEvent
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if requests++; requests <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		event := pagerDutyEvent{}
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received = append(received, event)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	return server, func() []pagerDutyEvent {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]pagerDutyEvent{}, received...)
+	}
+}
+
+func TestAlertOnTransition(t *testing.T) {
+	server, received := pagerDutyServer(0)
+	defer server.Close()
+
+	c := newTestController(t)
+	c.Alerter = NewAlerter(server.URL, "routing-key")
+	c.Config = &ControllerConfig{
+		Stage: "production",
+		Targets: []Target{
+			{Name: "server-a", Namespace: "default", Alert: true},
+			{Name: "server-b", Namespace: "default", Alert: false},
+		},
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.Alerter.Run(stop)
+
+	// server-b is not alerting; server-a goes down and recovers
+	for _, update := range []struct {
+		name  string
+		ready int32
+	}{{"server-b", 0}, {"server-a", 0}, {"server-a", 2}} {
+		c.Indexers[KindDeployment].Add(deployment(update.name, 2, update.ready, nil))
+		c.syncToStdout("Deployment/default/" + update.name)
+	}
+
+	if !waitFor(5*time.Second, func() bool { return len(received()) >= 2 }) {
+		t.Fatalf("Unexpected number of PagerDuty events %d; expected 2", len(received()))
+	}
+	events := received()
+	for i, action := range []string{"trigger", "resolve"} {
+		if events[i].EventAction != action {
+			t.Errorf("Unexpected event action %s; expected %s", events[i].EventAction, action)
+		}
+		if events[i].RoutingKey != "routing-key" {
+			t.Errorf("Unexpected routing key %s", events[i].RoutingKey)
+		}
+		if events[i].DedupKey != "dora-metrics/Deployment/default/server-a" {
+			t.Errorf("Unexpected dedup key %s", events[i].DedupKey)
+		}
+	}
+	if events[0].Payload == nil || events[0].Payload.Severity != "critical" {
+		t.Fatalf("Expected trigger event to carry a critical payload")
+	}
+	if summary := events[0].Payload.Summary; summary != "Deployment server-a in namespace default is down (stage production)" {
+		t.Errorf("Unexpected summary %q", summary)
+	}
+}
+
+func TestAlerterRetry(t *testing.T) {
+	var tests = []struct {
+		description string
+		failures    int
+		delivered   bool
+	}{
+		{"first_attempt", 0, true},
+		{"retried", 2, true},
+		{"given_up", alertAttempts, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			server, received := pagerDutyServer(test.failures)
+			defer server.Close()
+			alerter := NewAlerter(server.URL, "routing-key")
+			alerter.Backoff = time.Millisecond
+
+			_, err := alerter.sendWithRetry(alerter.resolveAlert(KindDeployment, "default", "server-a"), make(chan struct{}))
+			if (err == nil) != test.delivered {
+				t.Errorf("Unexpected error %v", err)
+			}
+			if delivered := len(received()) == 1; delivered != test.delivered {
+				t.Errorf("Unexpected delivered=%t; expected %t", delivered, test.delivered)
+			}
+		})
+	}
+}
+
+func TestAlerterRejected(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, `{"status":"invalid event"}`, http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	alerter := NewAlerter(server.URL, "routing-key")
+	_, err := alerter.sendWithRetry(alerter.createAlert(KindDeployment, "default", "server-a", availabilityDown, "production"), make(chan struct{}))
+	if err == nil {
+		t.Errorf("Expected an error for a rejected event")
+	}
+	// an invalid event won't become valid by sending it again
+	if requests != 1 {
+		t.Errorf("Unexpected number of requests %d; expected 1", requests)
+	}
+}
+
+func TestAlerterQueue(t *testing.T) {
+	alerter := NewAlerter("", "routing-key")
+	for i := 0; i < 150; i++ {
+		name := fmt.Sprintf("server-%d", i)
+		alerter.enqueue(alerter.createAlert(KindDeployment, "default", name, availabilityDegraded, "production"))
+		alerter.enqueue(alerter.createAlert(KindDeployment, "default", name, availabilityDown, "production"))
+		alerter.enqueue(alerter.resolveAlert(KindDeployment, "default", name))
+	}
+	// a trigger after a pending resolve is a new incident
+	alerter.enqueue(alerter.createAlert(KindDeployment, "default", "server-0", availabilityDown, "production"))
+
+	var events []pagerDutyEvent
+	for event, ok := alerter.next(); ok; event, ok = alerter.next() {
+		events = append(events, event)
+	}
+	if len(events) != 301 {
+		t.Fatalf("Unexpected number of queued events %d; expected 301", len(events))
+	}
+	// repeated triggers are sent once, with the latest state
+	if events[0].EventAction != "trigger" || events[0].Payload.CustomDetails["state"] != availabilityDown {
+		t.Errorf("Unexpected first event %+v", events[0])
+	}
+	for i := 0; i < 150; i++ {
+		if events[2*i].EventAction != "trigger" || events[2*i+1].EventAction != "resolve" {
+			t.Fatalf("Unexpected events %s and %s for server-%d", events[2*i].EventAction, events[2*i+1].EventAction, i)
+		}
+	}
+	if events[300].EventAction != "trigger" || events[300].DedupKey != "dora-metrics/Deployment/default/server-0" {
+		t.Errorf("Unexpected last event %+v", events[300])
+	}
+}
+
+func TestAlerterRequeuesResolve(t *testing.T) {
+	// PagerDuty is down for longer than a resolve is retried
+	server, received := pagerDutyServer(alertAttempts + 1)
+	defer server.Close()
+	alerter := NewAlerter(server.URL, "routing-key")
+	alerter.Backoff = time.Millisecond
+	stop := make(chan struct{})
+	defer close(stop)
+	go alerter.Run(stop)
+
+	alerter.enqueue(alerter.resolveAlert(KindDeployment, "default", "server-a"))
+	if !waitFor(5*time.Second, func() bool { return len(received()) == 1 }) {
+		t.Fatalf("Expected the resolve to be delivered")
+	}
+	if event := received()[0]; event.EventAction != "resolve" {
+		t.Errorf("Unexpected event action %s; expected resolve", event.EventAction)
+	}
+}
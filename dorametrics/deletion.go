@@ -0,0 +1,128 @@
+package dorametrics
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	au "github.com/logrusorgru/aurora"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/client-go/tools/cache"
+)
+
+// seriesVec is any of the metric vectors labelled by workload
+type seriesVec interface {
+	DeletePartialMatch(labels prometheus.Labels) int
+}
+
+func (collectors *Collectors) workloadVecs() []seriesVec {
+	return []seriesVec{
+		&collectors.CycleTimeGauge,
+		&collectors.TimeToRecoveryGauge,
+		&collectors.CycleTimeHistogram,
+		&collectors.TimeToRecoveryHistogram,
+		&collectors.LeadTimeHistogram,
+		&collectors.RolloutDurationHistogram,
+		&collectors.SuccessCounter,
+		&collectors.FailureCounter,
+		&collectors.DowntimeCounter,
+		&collectors.DeploymentEventCounter,
+		&collectors.ClampedCounter,
+		&collectors.ChangeFailureCounter,
+	}
+}
+
+// deleteWorkloadSeries removes every series of a vector that belongs to the
+// workload, whatever its other labels, and returns how many it removed
+func deleteWorkloadSeries(vec seriesVec, kind string, namespace string, name string) int {
+	return vec.DeletePartialMatch(prometheus.Labels{"deployment": name, "namespace": namespace, "kind": kind})
+}
+
+// workloadDeleted forgets a deleted workload once the retention delay has
+// passed: its metric series, state and deduplication entry. A workload
+// recreated in the meantime keeps them.
+func (c *Controller) workloadDeleted(key string, kind string, namespace string, name string) {
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+
+	info, tracked := c.State[key]
+	if _, ok := c.Dedup[key]; !tracked && !ok {
+		return
+	}
+
+	now := time.Now().Unix()
+	if info.DeletedAt == 0 {
+		log.Println(fmt.Sprintf("%s: %s %s in namespace %s has been deleted", au.Bold(au.Cyan("INFO")), kind, au.Bold(name), au.Bold(namespace)))
+		info.DeletedAt = now
+		if tracked {
+			c.State[key] = info
+			c.persistState()
+		}
+	}
+	if remaining := time.Duration(info.DeletedAt-now)*time.Second + c.DeletionRetention; remaining > 0 {
+		c.Queue.AddAfter(key, remaining)
+		return
+	}
+
+	deleted := c.forgetWorkload(key, kind, namespace, name)
+	log.Println(fmt.Sprintf("%s: removed %d metric series and the state of deleted %s %s in namespace %s", au.Bold(au.Cyan("INFO")), deleted, kind, au.Bold(name), au.Bold(namespace)))
+}
+
+// forgetWorkload removes the metric series, state and deduplication entry
+// of a workload and returns how many series it removed; callers hold c.Mutex
+func (c *Controller) forgetWorkload(key string, kind string, namespace string, name string) int {
+	// a page for an outage of a workload we no longer watch won't resolve
+	// itself. Its target may have been removed from the configuration
+	// already, so resolve without looking it up; PagerDuty ignores a resolve
+	// for an incident that was never opened.
+	if c.State[key].ErrorStart > 0 && c.Alerter != nil {
+		log.Println(fmt.Sprintf("%s: resolving PagerDuty incident for %s %s in namespace %s", au.Bold(au.Cyan("INFO")), kind, au.Bold(name), au.Bold(namespace)))
+		c.Alerter.enqueue(c.Alerter.resolveAlert(kind, namespace, name))
+	}
+
+	deleted := 0
+	for _, vec := range c.Collectors.workloadVecs() {
+		deleted += deleteWorkloadSeries(vec, kind, namespace, name)
+	}
+	delete(c.State, key)
+	delete(c.Dedup, key)
+	delete(c.Dedup, key+ingestDedupSuffix)
+	c.persistState()
+	return deleted
+}
+
+// queueVanished queues the workloads in State and Dedup that the synced
+// informers don't list, e.g. deleted while no controller was running, so
+// that they are forgotten like any deleted workload. Workloads of kinds that
+// are no longer watched are forgotten at once.
+func (c *Controller) queueVanished() {
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+
+	keys := map[string]bool{}
+	for key := range c.State {
+		keys[key] = true
+	}
+	for key := range c.Dedup {
+		keys[dedupWorkloadKey(key)] = true
+	}
+	for key := range keys {
+		kind, metaKey, err := splitWorkloadKey(key)
+		if err != nil {
+			continue
+		}
+		indexer, watched := c.Indexers[kind]
+		if !watched {
+			namespace, name, err := cache.SplitMetaNamespaceKey(metaKey)
+			if err != nil {
+				continue
+			}
+			deleted := c.forgetWorkload(key, kind, namespace, name)
+			log.Println(fmt.Sprintf("%s: removed %d metric series and the state of %s %s in namespace %s, whose kind is no longer watched", au.Bold(au.Cyan("INFO")), deleted, kind, au.Bold(name), au.Bold(namespace)))
+			continue
+		}
+		if _, exists, err := indexer.GetByKey(metaKey); err == nil && !exists {
+			c.Queue.Add(key)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package dorametrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestWorkloadKeyTombstone(t *testing.T) {
+	d := deployment("server-a", 1, 1, nil)
+	var tests = []struct {
+		description string
+		obj         interface{}
+	}{
+		{"object", d},
+		{"tombstone", cache.DeletedFinalStateUnknown{Key: "default/server-a", Obj: d}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			key, err := workloadKey(KindDeployment, test.obj)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if key != "Deployment/default/server-a" {
+				t.Errorf("Unexpected key %s; expected Deployment/default/server-a", key)
+			}
+		})
+	}
+}
+
+func TestWorkloadDeleted(t *testing.T) {
+	var tests = []struct {
+		description string
+		retention   time.Duration
+		purged      bool
+	}{
+		{"immediately", 0, true},
+		{"after_retention", time.Hour, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			c := newTestController(t)
+			c.DeletionRetention = test.retention
+			key := "Deployment/default/server-a"
+			labels := c.metricLabels("server-a", "default", KindDeployment)
+			otherLabels := c.metricLabels("server-b", "default", KindDeployment)
+
+			d := deployment("server-a", 2, 0, nil)
+			c.Indexers[KindDeployment].Add(d)
+			if err := c.syncToStdout(key); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			c.Dedup[key] = "1626600056"
+			c.Collectors.SuccessCounter.With(labels).Inc()
+			c.Collectors.SuccessCounter.With(otherLabels).Inc()
+
+			c.Indexers[KindDeployment].Delete(d)
+			if err := c.syncToStdout(key); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			_, stateKept := c.State[key]
+			_, dedupKept := c.Dedup[key]
+			if stateKept == test.purged || dedupKept == test.purged {
+				t.Errorf("Unexpected state kept=%t, dedup kept=%t; expected purged=%t", stateKept, dedupKept, test.purged)
+			}
+			series := 2
+			if test.purged {
+				series = 1
+			}
+			if count := testutil.CollectAndCount(&c.Collectors.SuccessCounter); count != series {
+				t.Errorf("Unexpected number of success series %d; expected %d", count, series)
+			}
+			if count := testutil.CollectAndCount(&c.Collectors.DowntimeCounter); count != series-1 {
+				t.Errorf("Unexpected number of downtime series %d; expected %d", count, series-1)
+			}
+
+			if !test.purged {
+				// recreated during the retention delay
+				c.Indexers[KindDeployment].Add(d)
+				if err := c.syncToStdout(key); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if c.State[key].DeletedAt != 0 {
+					t.Errorf("Expected deletion to be cancelled")
+				}
+			}
+		})
+	}
+}
+
+func TestQueueVanished(t *testing.T) {
+	c := newTestController(t)
+	delete(c.Indexers, KindRollout)
+	c.Indexers[KindDeployment].Add(deployment("server-a", 2, 2, nil))
+	c.State["Deployment/default/server-a"] = DeploymentInfo{Name: "server-a", Namespace: "default", Kind: KindDeployment}
+	c.State["Deployment/default/server-b"] = DeploymentInfo{Name: "server-b", Namespace: "default", Kind: KindDeployment}
+	c.Dedup["StatefulSet/default/server-c"] = "1626600056"
+	c.State["Rollout/default/server-d"] = DeploymentInfo{Name: "server-d", Namespace: "default", Kind: KindRollout}
+
+	c.queueVanished()
+
+	// deleted workloads go through the usual deletion, which honours retention
+	if c.Queue.Len() != 2 {
+		t.Fatalf("Unexpected queue length %d; expected 2", c.Queue.Len())
+	}
+	queued := map[interface{}]bool{}
+	for c.Queue.Len() > 0 {
+		key, _ := c.Queue.Get()
+		queued[key] = true
+		c.Queue.Done(key)
+	}
+	for _, key := range []string{"Deployment/default/server-b", "StatefulSet/default/server-c"} {
+		if !queued[key] {
+			t.Errorf("Expected %s to be queued", key)
+		}
+	}
+	if _, ok := c.State["Rollout/default/server-d"]; ok {
+		t.Errorf("Expected the state of a kind that is no longer watched to be removed")
+	}
+	if _, ok := c.State["Deployment/default/server-a"]; !ok {
+		t.Errorf("Expected the state of an existing workload to be kept")
+	}
+}
@@ -0,0 +1,170 @@
+package dorametrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	au "github.com/logrusorgru/aurora"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DeploymentEvent describes a single deployment outcome, whatever its source
+type DeploymentEvent struct {
+	ID               string  `json:"id,omitempty"` // optional; a retry with the same id is counted once
+	Service          string  `json:"service"`
+	Namespace        string  `json:"namespace"`
+	Kind             string  `json:"kind,omitempty"`
+	Success          bool    `json:"success"`
+	CycleTimeSeconds int64   `json:"cycleTimeSeconds,omitempty"`
+	CommitSHA        string  `json:"commitSha,omitempty"`
+	CommitTimestamp  int64   `json:"commitTimestamp,omitempty"`  // unix seconds
+	CommitTimestamps []int64 `json:"commitTimestamps,omitempty"` // unix seconds, for batched releases
+	StartedAt        int64   `json:"startedAt,omitempty"`        // unix seconds
+	FinishedAt       int64   `json:"finishedAt,omitempty"`       // unix seconds
+	Flags            string  `json:"flags,omitempty"`            // set by the controller, see getDeploymentFlags
+}
+
+// commitTimestamps merges the single and batched commit timestamps
+func (event DeploymentEvent) commitTimestamps() []int64 {
+	timestamps := event.CommitTimestamps
+	if event.CommitTimestamp > 0 {
+		timestamps = append([]int64{event.CommitTimestamp}, timestamps...)
+	}
+	return timestamps
+}
+
+// reportDeployment updates the deployment collectors; callers hold c.Mutex.
+// Only successes reported in annotations are verified against the pods, by
+// syncToStdout. Events from CI and CDEvents say when the deployment finished,
+// possibly long ago after retries or in a backfill, when the pods may have
+// moved on to a later revision, so they are taken as reported.
+func (c *Controller) reportDeployment(event DeploymentEvent) {
+	metricLabels := c.metricLabels(event.Service, event.Namespace, event.Kind)
+	c.classifyDeployment(&event, metricLabels)
+
+	cycleTimeSeconds := event.CycleTimeSeconds
+	if cycleTimeSeconds == 0 && event.StartedAt > 0 && event.FinishedAt > event.StartedAt {
+		cycleTimeSeconds = event.FinishedAt - event.StartedAt
+	}
+	deployedAt := event.FinishedAt
+	if deployedAt == 0 {
+		deployedAt = time.Now().Unix()
+	}
+
+	eventType := EventDeploymentSucceeded
+	if !event.Success {
+		eventType = EventDeploymentFailed
+	}
+	c.recordEvent(EventRecord{
+		Type:             eventType,
+		Time:             deployedAt,
+		Kind:             event.Kind,
+		Namespace:        event.Namespace,
+		Name:             event.Service,
+		DurationSeconds:  cycleTimeSeconds,
+		Images:           c.State[fmt.Sprintf("%s/%s/%s", event.Kind, event.Namespace, event.Service)].Images,
+		CommitSHA:        event.CommitSHA,
+		CommitTimestamps: event.commitTimestamps(),
+		Flags:            event.Flags,
+	}, metricLabels)
+
+	// we don't measure cycle time for failed deployments
+	if !event.Success {
+		log.Println(fmt.Sprintf("%s: reporting failed deployment for %s %s in namespace %s", au.Bold(au.Cyan("INFO")), event.Kind, au.Bold(event.Service), au.Bold(event.Namespace)))
+		c.Collectors.FailureCounter.With(metricLabels).Inc()
+		return
+	}
+
+	if cycleTimeSeconds > 0 {
+		cycleTimeSeconds = c.clampObservation(observationCycleTime, cycleTimeSeconds, event.Kind, event.Namespace, event.Service, metricLabels)
+		log.Println(fmt.Sprintf("%s: submitting cycle time %d for %s %s in namespace %s", au.Bold(au.Cyan("INFO")), au.Bold(cycleTimeSeconds), event.Kind, au.Bold(event.Service), au.Bold(event.Namespace)))
+		c.Collectors.CycleTimeGauge.With(metricLabels).Set(float64(cycleTimeSeconds))
+		c.Collectors.CycleTimeHistogram.With(metricLabels).Observe(float64(cycleTimeSeconds))
+	}
+
+	// lead time for changes runs from each commit to the moment we see the deployment succeed
+	for _, commitTimestamp := range event.commitTimestamps() {
+		leadTime := deployedAt - commitTimestamp
+		if commitTimestamp <= 0 || leadTime < 0 {
+			continue
+		}
+		log.Println(fmt.Sprintf("%s: submitting lead time %d for commit %s of %s %s in namespace %s", au.Bold(au.Cyan("INFO")), au.Bold(leadTime), event.CommitSHA, event.Kind, au.Bold(event.Service), au.Bold(event.Namespace)))
+		c.Collectors.LeadTimeHistogram.With(metricLabels).Observe(float64(leadTime))
+	}
+
+	// report success
+	c.Collectors.SuccessCounter.With(metricLabels).Inc()
+
+	// remember the deployment, so that incidents can be attributed to it
+	key := fmt.Sprintf("%s/%s/%s", event.Kind, event.Namespace, event.Service)
+	if info, ok := c.State[key]; ok {
+		info.LastDeployedAt = deployedAt
+		c.State[key] = info
+		c.persistState()
+	}
+}
+
+// classifyDeployment compares the deployment with the previous one reported
+// for the workload, counts it by flags and remembers its outcome and images;
+// callers hold c.Mutex
+func (c *Controller) classifyDeployment(event *DeploymentEvent, metricLabels prometheus.Labels) {
+	key := fmt.Sprintf("%s/%s/%s", event.Kind, event.Namespace, event.Service)
+	info, ok := c.State[key]
+	if !ok {
+		info = DeploymentInfo{Name: event.Service, Namespace: event.Namespace, Kind: event.Kind}
+	}
+
+	images := strings.Join(c.workloadImages(event.Kind, event.Namespace, event.Service), ",")
+	event.Flags = getDeploymentFlags(event.Success, !info.PreviousFailure, images != info.Images)
+
+	eventLabels := flagLabels(event.Flags)
+	for name, value := range metricLabels {
+		eventLabels[name] = value
+	}
+	c.Collectors.DeploymentEventCounter.With(eventLabels).Inc()
+
+	info.Images = images
+	info.PreviousFailure = !event.Success
+	c.State[key] = info
+	c.persistState()
+
+	if c.Debug {
+		bytes, err := json.Marshal(event)
+		if err != nil {
+			log.Println(fmt.Sprintf("%s: %s", au.Bold(au.Red("Error")), au.Bold(err)))
+			return
+		}
+		// main JSON output goes to stdout
+		fmt.Printf("%s\n", bytes)
+	}
+}
+
+// workloadImages looks up the current images of a workload; empty if the
+// informers don't know it
+func (c *Controller) workloadImages(kind string, namespace string, name string) []string {
+	workload := c.lookupWorkload(kind, namespace, name)
+	if workload == nil {
+		return nil
+	}
+	return workload.Images()
+}
+
+// lookupWorkload finds a workload in the informer caches; nil if unknown
+func (c *Controller) lookupWorkload(kind string, namespace string, name string) Workload {
+	indexer, ok := c.Indexers[kind]
+	if !ok {
+		return nil
+	}
+	obj, exists, err := indexer.GetByKey(namespace + "/" + name)
+	if err != nil || !exists {
+		return nil
+	}
+	workload, err := asWorkload(obj)
+	if err != nil {
+		return nil
+	}
+	return workload
+}
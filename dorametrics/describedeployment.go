@@ -11,6 +11,7 @@ func describeDeployment(deployment DeploymentInfo) string {
 	var items []string
 	items = append(items, fmt.Sprintf("%s:", au.Bold(au.Cyan("DEBUG"))))
 	items = append(items, fmt.Sprintf("Name=%s", au.Bold(deployment.Name)))
+	items = append(items, fmt.Sprintf(" Kind=%s", au.Bold(deployment.Kind)))
 	items = append(items, fmt.Sprintf(" Namespace=%s", au.Bold(deployment.Namespace)))
 	items = append(items, fmt.Sprintf(" Replicas=%d", au.Bold(deployment.Replicas)))
 	items = append(items, fmt.Sprintf(" ReadyReplicas=%d", au.Bold(deployment.ReadyReplicas)))
@@ -12,7 +12,7 @@ func TestDescribeDeployment(t *testing.T) {
 		deployment  DeploymentInfo
 		expected    string
 	}{
-		{"successful_deployment", DeploymentInfo{"server-c", "default", 1, 1, 0}, "DEBUG: Name=server-c  Namespace=default  Replicas=1  ReadyReplicas=1  ErrorStart=0"},
+		{"successful_deployment", DeploymentInfo{Name: "server-c", Namespace: "default", Kind: "Deployment", Replicas: 1, ReadyReplicas: 1}, "DEBUG: Name=server-c  Kind=Deployment  Namespace=default  Replicas=1  ReadyReplicas=1  ErrorStart=0"},
 	}
 
 	for _, test := range tests {
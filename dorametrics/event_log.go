@@ -0,0 +1,153 @@
+package dorametrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	au "github.com/logrusorgru/aurora"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// event record types
+const (
+	EventDeploymentSucceeded = "deployment_succeeded"
+	EventDeploymentFailed    = "deployment_failed"
+	EventOutageOpened        = "outage_opened"
+	EventOutageClosed        = "outage_closed"
+	EventChangeFailure       = "change_failure"
+)
+
+// EventRecord is one line of the event log
+type EventRecord struct {
+	Type             string            `json:"type"`
+	Time             int64             `json:"time"` // unix seconds
+	Kind             string            `json:"kind"`
+	Namespace        string            `json:"namespace"`
+	Name             string            `json:"name"`
+	Labels           map[string]string `json:"labels"`
+	DurationSeconds  int64             `json:"durationSeconds,omitempty"` // cycle time or time to recovery, before any cap
+	State            string            `json:"state,omitempty"`           // down or degraded, for outages
+	Start            int64             `json:"start,omitempty"`           // unix seconds, for closed outages
+	Images           string            `json:"images,omitempty"`
+	CommitSHA        string            `json:"commitSha,omitempty"`
+	CommitTimestamps []int64           `json:"commitTimestamps,omitempty"`
+	Flags            string            `json:"flags,omitempty"`
+	IncidentID       string            `json:"incidentId,omitempty"`
+	Source           string            `json:"source,omitempty"`     // tool that reported an incident
+	DeployedAt       int64             `json:"deployedAt,omitempty"` // unix seconds, of the deployment blamed for a change failure
+}
+
+// EventLog keeps a durable record of DORA events
+type EventLog interface {
+	Record(event EventRecord) error
+}
+
+// NewEventLog returns the event log for the given kind ("none", "stdout" or
+// "file"); file logs are rotated once they exceed maxBytes, keeping maxFiles
+// rotated files
+func NewEventLog(kind string, path string, maxBytes int64, maxFiles int) (EventLog, error) {
+	switch kind {
+	case "", "none":
+		return nil, nil
+	case "stdout":
+		return &WriterEventLog{Writer: os.Stdout}, nil
+	case "file":
+		if len(path) == 0 {
+			return nil, fmt.Errorf("file event log requires a path")
+		}
+		return &FileEventLog{Path: path, MaxBytes: maxBytes, MaxFiles: maxFiles}, nil
+	}
+	return nil, fmt.Errorf("unknown event log %s", kind)
+}
+
+// WriterEventLog writes JSON Lines to a stream
+type WriterEventLog struct {
+	Writer io.Writer
+	mutex  sync.Mutex
+}
+
+// Record writes the event as a single line
+func (l *WriterEventLog) Record(event EventRecord) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	_, err = l.Writer.Write(append(line, '\n'))
+	return err
+}
+
+// FileEventLog appends JSON Lines to a file, rotating it by size to
+// PATH.1 ... PATH.MaxFiles
+type FileEventLog struct {
+	Path     string
+	MaxBytes int64 // 0 disables rotation
+	MaxFiles int
+	mutex    sync.Mutex
+}
+
+// Record appends the event, rotating the file first if it would grow too large
+func (l *FileEventLog) Record(event EventRecord) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.MaxBytes > 0 {
+		info, err := os.Stat(l.Path)
+		if err == nil && info.Size() > 0 && info.Size()+int64(len(line)) > l.MaxBytes {
+			if err := l.rotate(); err != nil {
+				return fmt.Errorf("can't rotate event log %s: %v", l.Path, err)
+			}
+		}
+	}
+
+	file, err := os.OpenFile(l.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("can't open event log %s: %v", l.Path, err)
+	}
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return fmt.Errorf("can't write event log %s: %v", l.Path, err)
+	}
+	return file.Close()
+}
+
+// rotate shifts PATH.N to PATH.N+1, dropping the oldest, and PATH to PATH.1
+func (l *FileEventLog) rotate() error {
+	if l.MaxFiles < 1 {
+		return os.Remove(l.Path)
+	}
+	os.Remove(fmt.Sprintf("%s.%d", l.Path, l.MaxFiles))
+	for i := l.MaxFiles - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", l.Path, i), fmt.Sprintf("%s.%d", l.Path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(l.Path, l.Path+".1")
+}
+
+// recordEvent writes an event to the event log, if there is one; failures
+// are logged rather than interrupting metrics
+func (c *Controller) recordEvent(event EventRecord, metricLabels prometheus.Labels) {
+	if c.EventLog == nil {
+		return
+	}
+	event.Labels = map[string]string{}
+	for name, value := range metricLabels {
+		event.Labels[name] = value
+	}
+	err := c.EventLog.Record(event)
+	if err != nil {
+		log.Println(fmt.Sprintf("%s: can't record %s event: %v", au.Bold(au.Red("Error")), event.Type, err))
+	}
+}
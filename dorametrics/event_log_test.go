@@ -0,0 +1,106 @@
+package dorametrics
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileEventLogRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	eventLog := &FileEventLog{Path: path, MaxBytes: 300, MaxFiles: 2}
+	for i := 0; i < 10; i++ {
+		err := eventLog.Record(EventRecord{Type: EventDeploymentSucceeded, Time: 1626600000 + int64(i), Kind: KindDeployment, Namespace: "default", Name: "server-a"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	var tests = []struct {
+		path   string
+		exists bool
+	}{
+		{path, true},
+		{path + ".1", true},
+		{path + ".2", true},
+		{path + ".3", false},
+	}
+	for _, test := range tests {
+		info, err := os.Stat(test.path)
+		if (err == nil) != test.exists {
+			t.Errorf("Unexpected existence of %s: %v", test.path, err)
+			continue
+		}
+		if err == nil && info.Size() > eventLog.MaxBytes {
+			t.Errorf("Unexpected size %d of %s; expected at most %d", info.Size(), test.path, eventLog.MaxBytes)
+		}
+	}
+
+	// the current file holds the latest event, complete
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Can't read event log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	var last EventRecord
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil {
+		t.Fatalf("Can't parse event: %v", err)
+	}
+	if last.Time != 1626600009 {
+		t.Errorf("Unexpected time %d of the last event; expected 1626600009", last.Time)
+	}
+}
+
+func TestRecordEvents(t *testing.T) {
+	c := newTestController(t)
+	var buffer bytes.Buffer
+	c.EventLog = &WriterEventLog{Writer: &buffer}
+	key := "Deployment/default/server-a"
+	sync := func(readyReplicas int32) {
+		c.Indexers[KindDeployment].Add(deployment("server-a", 2, readyReplicas, nil))
+		if err := c.syncToStdout(key); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	sync(2)
+	c.reportDeployment(DeploymentEvent{Service: "server-a", Namespace: "default", Kind: KindDeployment, Success: true, CycleTimeSeconds: 9000, CommitSHA: "4f2a9c1"})
+	sync(0)
+	sync(2)
+	c.reportDeployment(DeploymentEvent{Service: "server-a", Namespace: "default", Kind: KindDeployment, Success: false})
+
+	var events []EventRecord
+	scanner := bufio.NewScanner(&buffer)
+	for scanner.Scan() {
+		var event EventRecord
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Can't parse event %s: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+
+	expected := []string{EventDeploymentSucceeded, EventOutageOpened, EventOutageClosed, EventDeploymentFailed}
+	if len(events) != len(expected) {
+		t.Fatalf("Unexpected events %+v; expected types %v", events, expected)
+	}
+	for i, event := range events {
+		if event.Type != expected[i] {
+			t.Errorf("Unexpected type %s of event %d; expected %s", event.Type, i, expected[i])
+		}
+		if event.Labels["team"] != defaultLabelValue || event.Name != "server-a" {
+			t.Errorf("Unexpected workload in event %+v", event)
+		}
+	}
+	// durations are recorded before any cap
+	if events[0].DurationSeconds != 9000 || events[0].CommitSHA != "4f2a9c1" {
+		t.Errorf("Unexpected deployment event %+v", events[0])
+	}
+	if events[1].State != availabilityDown || events[2].Start != events[1].Time {
+		t.Errorf("Unexpected outage events %+v and %+v", events[1], events[2])
+	}
+}
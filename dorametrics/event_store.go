@@ -0,0 +1,331 @@
+package dorametrics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	au "github.com/logrusorgru/aurora"
+	bolt "go.etcd.io/bbolt"
+)
+
+// EventStore keeps DORA events in an embedded bbolt database, so that
+// reports can span more than the Prometheus retention. Events are keyed by
+// time, so that queries only read the range they select, and indexed by
+// namespace. Events older than the retention period are deleted once a day;
+// bbolt reuses their pages, so the file stops growing once it holds a
+// retention period's worth of events.
+type EventStore struct {
+	Path      string
+	Retention time.Duration // 0 keeps events forever
+	db        *bolt.DB
+	mutex     sync.Mutex
+	prunedDay int64
+}
+
+var (
+	eventsBucket     = []byte("events")
+	namespacesBucket = []byte("namespaces") // a bucket of event keys per namespace
+)
+
+// eventStoreOpenTimeout bounds the wait for another process to release the database
+const eventStoreOpenTimeout = 10 * time.Second
+
+// OpenEventStore opens or creates the database at path and deletes the
+// events that have left the retention period
+func OpenEventStore(path string, retention time.Duration) (*EventStore, error) {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: eventStoreOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("can't open event store %s: %v", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{eventsBucket, namespacesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("can't initialise event store %s: %v", path, err)
+	}
+
+	store := &EventStore{Path: path, Retention: retention, db: db}
+	if err := store.prune(time.Now().Unix()); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// Close releases the database
+func (s *EventStore) Close() error {
+	return s.db.Close()
+}
+
+// timeKey is the big-endian unix time that event keys start with, so that
+// keys sort by time; times before 1970 sort first
+func timeKey(at int64) []byte {
+	if at < 0 {
+		at = 0
+	}
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(at))
+	return key
+}
+
+// eventKey orders events by time, then in the order they were recorded
+func eventKey(at int64, sequence uint64) []byte {
+	key := make([]byte, 16)
+	copy(key, timeKey(at))
+	binary.BigEndian.PutUint64(key[8:], sequence)
+	return key
+}
+
+// Record stores the event unless it's older than the retention period
+func (s *EventStore) Record(event EventRecord) error {
+	now := time.Now().Unix()
+	if s.Retention > 0 && event.Time < now-int64(s.Retention.Seconds()) {
+		return nil
+	}
+	value, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		events := tx.Bucket(eventsBucket)
+		sequence, err := events.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := eventKey(event.Time, sequence)
+		if err := events.Put(key, value); err != nil {
+			return err
+		}
+		if len(event.Namespace) == 0 {
+			return nil
+		}
+		namespace, err := tx.Bucket(namespacesBucket).CreateBucketIfNotExists([]byte(event.Namespace))
+		if err != nil {
+			return err
+		}
+		return namespace.Put(key, []byte{})
+	})
+	if err != nil {
+		return fmt.Errorf("can't write event store %s: %v", s.Path, err)
+	}
+
+	s.mutex.Lock()
+	due := now/day > s.prunedDay
+	s.mutex.Unlock()
+	if due {
+		return s.prune(now)
+	}
+	return nil
+}
+
+// prune deletes the events that have left the retention period
+func (s *EventStore) prune(now int64) error {
+	s.mutex.Lock()
+	s.prunedDay = now / day
+	s.mutex.Unlock()
+	if s.Retention <= 0 {
+		return nil
+	}
+
+	first := timeKey(now - int64(s.Retention.Seconds()))
+	deleted := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		buckets := []*bolt.Bucket{tx.Bucket(eventsBucket)}
+		namespaces := tx.Bucket(namespacesBucket)
+		err := namespaces.ForEach(func(name []byte, _ []byte) error {
+			buckets = append(buckets, namespaces.Bucket(name))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for i, bucket := range buckets {
+			// deleting moves the cursor on, so start over every time
+			cursor := bucket.Cursor()
+			for key, _ := cursor.First(); key != nil && bytes.Compare(key, first) < 0; key, _ = cursor.First() {
+				if err := cursor.Delete(); err != nil {
+					return err
+				}
+				if i == 0 {
+					deleted++
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("can't prune event store %s: %v", s.Path, err)
+	}
+	if deleted > 0 {
+		log.Println(fmt.Sprintf("%s: deleted %d events older than %s from event store %s", au.Bold(au.Cyan("INFO")), deleted, s.Retention, s.Path))
+	}
+	return nil
+}
+
+// scan passes the events from from to to (unix seconds, 0 for no bound) and
+// of the namespace, if set, to fn, in the order they happened
+func (s *EventStore) scan(from int64, to int64, namespace string, fn func(EventRecord)) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		events := tx.Bucket(eventsBucket)
+		keys := events
+		if len(namespace) > 0 {
+			if keys = tx.Bucket(namespacesBucket).Bucket([]byte(namespace)); keys == nil {
+				return nil
+			}
+		}
+		cursor := keys.Cursor()
+		for key, _ := cursor.Seek(timeKey(from)); key != nil; key, _ = cursor.Next() {
+			if to != 0 && bytes.Compare(key, timeKey(to)) >= 0 {
+				break
+			}
+			value := events.Get(key)
+			if value == nil {
+				continue
+			}
+			record := EventRecord{}
+			if err := json.Unmarshal(value, &record); err != nil {
+				return fmt.Errorf("can't parse event %x of event store %s: %v", key, s.Path, err)
+			}
+			fn(record)
+		}
+		return nil
+	})
+}
+
+// EventFilter selects events; empty fields match anything
+type EventFilter struct {
+	Namespace string
+	Team      string
+	Service   string
+	From      int64 // unix seconds, inclusive
+	To        int64 // unix seconds, exclusive
+	Outcome   string
+}
+
+func (f EventFilter) matches(record EventRecord, time int64) bool {
+	return (len(f.Namespace) == 0 || record.Namespace == f.Namespace) &&
+		(len(f.Team) == 0 || record.Labels["team"] == f.Team) &&
+		(len(f.Service) == 0 || record.Labels["service"] == f.Service) &&
+		(f.From == 0 || time >= f.From) &&
+		(f.To == 0 || time < f.To)
+}
+
+// DeploymentRecord is a deployment returned by the query API
+type DeploymentRecord struct {
+	EventRecord
+	Outcome string `json:"outcome"`
+}
+
+// Deployments lists the deployments matching the filter, oldest first; the
+// outcome is "success" or "failure"
+func (s *EventStore) Deployments(filter EventFilter) ([]DeploymentRecord, error) {
+	deployments := []DeploymentRecord{}
+	err := s.scan(filter.From, filter.To, filter.Namespace, func(record EventRecord) {
+		outcome := "success"
+		switch record.Type {
+		case EventDeploymentSucceeded:
+		case EventDeploymentFailed:
+			outcome = "failure"
+		default:
+			return
+		}
+		if !filter.matches(record, record.Time) || (len(filter.Outcome) > 0 && outcome != filter.Outcome) {
+			return
+		}
+		deployments = append(deployments, DeploymentRecord{EventRecord: record, Outcome: outcome})
+	})
+	return deployments, err
+}
+
+// IncidentRecord is an outage returned by the query API; End is 0 while it's open
+type IncidentRecord struct {
+	Kind            string            `json:"kind"`
+	Namespace       string            `json:"namespace"`
+	Name            string            `json:"name"`
+	Labels          map[string]string `json:"labels"`
+	State           string            `json:"state"`
+	Start           int64             `json:"start"`
+	End             int64             `json:"end,omitempty"`
+	DurationSeconds int64             `json:"durationSeconds,omitempty"`
+	IncidentID      string            `json:"incidentId,omitempty"`
+	Source          string            `json:"source,omitempty"` // tool that reported the incident
+	Outcome         string            `json:"outcome"`          // "resolved" or "open"
+}
+
+// Incidents pairs opened and closed outages and lists those matching the
+// filter by start time, oldest first; the outcome is "resolved" or "open"
+func (s *EventStore) Incidents(filter EventFilter) ([]IncidentRecord, error) {
+	var incidents []IncidentRecord
+	open := map[string]int{} // outage key to index in incidents
+	// outages are matched by start, but may close after filter.To
+	err := s.scan(filter.From, 0, filter.Namespace, func(record EventRecord) {
+		key := fmt.Sprintf("%s/%s/%s/%s/%s", record.Kind, record.Namespace, record.Name, record.Source, record.IncidentID)
+		switch record.Type {
+		case EventOutageOpened:
+			open[key] = len(incidents)
+			incidents = append(incidents, IncidentRecord{
+				Kind:       record.Kind,
+				Namespace:  record.Namespace,
+				Name:       record.Name,
+				Labels:     record.Labels,
+				State:      record.State,
+				Start:      record.Time,
+				IncidentID: record.IncidentID,
+				Source:     record.Source,
+				Outcome:    "open",
+			})
+		case EventOutageClosed:
+			i, ok := open[key]
+			if !ok {
+				// opened before the store was
+				i = len(incidents)
+				incidents = append(incidents, IncidentRecord{Kind: record.Kind, Namespace: record.Namespace, Name: record.Name, IncidentID: record.IncidentID, Source: record.Source, Start: record.Start})
+			}
+			delete(open, key)
+			incidents[i].Labels = record.Labels
+			incidents[i].State = record.State
+			incidents[i].End = record.Time
+			incidents[i].DurationSeconds = record.DurationSeconds
+			incidents[i].Outcome = "resolved"
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	matching := []IncidentRecord{}
+	for _, incident := range incidents {
+		record := EventRecord{Namespace: incident.Namespace, Labels: incident.Labels}
+		if !filter.matches(record, incident.Start) || (len(filter.Outcome) > 0 && incident.Outcome != filter.Outcome) {
+			continue
+		}
+		matching = append(matching, incident)
+	}
+	return matching, nil
+}
+
+// MultiEventLog records events in several logs
+type MultiEventLog []EventLog
+
+// Record records the event everywhere, returning the first error
+func (logs MultiEventLog) Record(event EventRecord) error {
+	var firstErr error
+	for _, eventLog := range logs {
+		if err := eventLog.Record(event); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,236 @@
+package dorametrics
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// openEventStore opens a store in a temporary directory, closed with the test
+func openEventStore(t *testing.T, path string, retention time.Duration) *EventStore {
+	store, err := OpenEventStore(path, retention)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func seededEventStore(t *testing.T) (*EventStore, string) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	store := openEventStore(t, path, 0)
+	teamA := map[string]string{"team": "team-a", "service": "server-a"}
+	teamB := map[string]string{"team": "team-b", "service": "server-b"}
+	records := []EventRecord{
+		{Type: EventDeploymentSucceeded, Time: 1626600000, Kind: KindDeployment, Namespace: "default", Name: "server-a", Labels: teamA, DurationSeconds: 300},
+		{Type: EventOutageOpened, Time: 1626600100, Kind: KindDeployment, Namespace: "default", Name: "server-a", Labels: teamA, State: availabilityDown},
+		{Type: EventDeploymentFailed, Time: 1626600200, Kind: KindDeployment, Namespace: "other", Name: "server-b", Labels: teamB},
+		{Type: EventOutageClosed, Time: 1626600400, Kind: KindDeployment, Namespace: "default", Name: "server-a", Labels: teamA, State: availabilityDown, Start: 1626600100, DurationSeconds: 300},
+		{Type: EventOutageOpened, Time: 1626600500, Kind: KindDeployment, Namespace: "other", Name: "server-b", Labels: teamB, State: availabilityDegraded},
+	}
+	for _, record := range records {
+		if err := store.Record(record); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	return store, path
+}
+
+func eventCount(t *testing.T, store *EventStore, namespace string) int {
+	count := 0
+	if err := store.scan(0, 0, namespace, func(EventRecord) { count++ }); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	return count
+}
+
+func TestEventStoreReopen(t *testing.T) {
+	store, path := seededEventStore(t)
+	expected := eventCount(t, store, "")
+	store.Close()
+
+	reopened := openEventStore(t, path, 0)
+	if count := eventCount(t, reopened, ""); count != expected || count != 5 {
+		t.Errorf("Unexpected number of events %d; expected %d", count, expected)
+	}
+	if count := eventCount(t, reopened, "other"); count != 2 {
+		t.Errorf("Unexpected number of events %d in namespace other; expected 2", count)
+	}
+}
+
+func TestEventStoreOrder(t *testing.T) {
+	store := openEventStore(t, filepath.Join(t.TempDir(), "events.db"), 0)
+	// recorded newest first, e.g. backfilled by CI
+	for i := 9; i >= 0; i-- {
+		if err := store.Record(EventRecord{Type: EventDeploymentSucceeded, Time: 1626600000 + int64(i)*day, Kind: KindDeployment, Namespace: "default", Name: "server-a"}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	deployments, err := store.Deployments(EventFilter{Namespace: "default", From: 1626600000 + 2*day, To: 1626600000 + 8*day})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(deployments) != 6 {
+		t.Fatalf("Unexpected number of deployments %d; expected 6", len(deployments))
+	}
+	for i, deployment := range deployments {
+		if deployment.Time != 1626600000+int64(i+2)*day {
+			t.Errorf("Unexpected time %d of deployment %d; expected oldest first", deployment.Time, i)
+		}
+	}
+}
+
+func TestEventStoreRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	now := time.Now().Unix()
+	store := openEventStore(t, path, 5*24*time.Hour)
+	for _, at := range []int64{now - 10*day, now - day, now} {
+		if err := store.Record(EventRecord{Type: EventDeploymentSucceeded, Time: at, Kind: KindDeployment, Namespace: "default", Name: "server-a"}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if count := eventCount(t, store, ""); count != 2 {
+		t.Errorf("Unexpected number of events %d; expected 2", count)
+	}
+	store.Close()
+
+	// events kept without retention are deleted once it applies, from the
+	// namespace index too
+	store = openEventStore(t, path, 0)
+	for _, at := range []int64{now - 30*day, now - 10*day} {
+		if err := store.Record(EventRecord{Type: EventDeploymentFailed, Time: at, Kind: KindDeployment, Namespace: "default", Name: "server-a"}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if count := eventCount(t, store, "default"); count != 4 {
+		t.Errorf("Unexpected number of events %d; expected 4", count)
+	}
+	store.Close()
+
+	reopened := openEventStore(t, path, 5*24*time.Hour)
+	if count := eventCount(t, reopened, ""); count != 2 {
+		t.Errorf("Unexpected number of events %d after pruning; expected 2", count)
+	}
+	if count := eventCount(t, reopened, "default"); count != 2 {
+		t.Errorf("Unexpected number of indexed events %d after pruning; expected 2", count)
+	}
+}
+
+func TestEventStoreDeployments(t *testing.T) {
+	store, _ := seededEventStore(t)
+	var tests = []struct {
+		description string
+		filter      EventFilter
+		expected    []string
+	}{
+		{"all", EventFilter{}, []string{"server-a", "server-b"}},
+		{"namespace", EventFilter{Namespace: "other"}, []string{"server-b"}},
+		{"team", EventFilter{Team: "team-a"}, []string{"server-a"}},
+		{"service", EventFilter{Service: "server-b"}, []string{"server-b"}},
+		{"outcome", EventFilter{Outcome: "success"}, []string{"server-a"}},
+		{"from", EventFilter{From: 1626600200}, []string{"server-b"}},
+		{"to", EventFilter{To: 1626600200}, []string{"server-a"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			deployments, err := store.Deployments(test.filter)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(deployments) != len(test.expected) {
+				t.Fatalf("Unexpected deployments %+v; expected %v", deployments, test.expected)
+			}
+			for i, deployment := range deployments {
+				if deployment.Name != test.expected[i] {
+					t.Errorf("Unexpected deployment %s; expected %s", deployment.Name, test.expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestEventStoreIncidents(t *testing.T) {
+	store, _ := seededEventStore(t)
+	var tests = []struct {
+		description string
+		filter      EventFilter
+		expected    []IncidentRecord
+	}{
+		{"all", EventFilter{}, []IncidentRecord{
+			{Name: "server-a", State: availabilityDown, Start: 1626600100, End: 1626600400, DurationSeconds: 300, Outcome: "resolved"},
+			{Name: "server-b", State: availabilityDegraded, Start: 1626600500, Outcome: "open"},
+		}},
+		{"open", EventFilter{Outcome: "open"}, []IncidentRecord{
+			{Name: "server-b", State: availabilityDegraded, Start: 1626600500, Outcome: "open"},
+		}},
+		{"by_start", EventFilter{To: 1626600500}, []IncidentRecord{
+			{Name: "server-a", State: availabilityDown, Start: 1626600100, End: 1626600400, DurationSeconds: 300, Outcome: "resolved"},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			incidents, err := store.Incidents(test.filter)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(incidents) != len(test.expected) {
+				t.Fatalf("Unexpected incidents %+v; expected %+v", incidents, test.expected)
+			}
+			for i, incident := range incidents {
+				expected := test.expected[i]
+				if incident.Name != expected.Name || incident.State != expected.State || incident.Start != expected.Start ||
+					incident.End != expected.End || incident.DurationSeconds != expected.DurationSeconds || incident.Outcome != expected.Outcome {
+					t.Errorf("Unexpected incident %+v; expected %+v", incident, expected)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryHandlers(t *testing.T) {
+	store, _ := seededEventStore(t)
+	handler := MethodHandler{http.MethodGet: &DeploymentsQueryHandler{Store: store}}
+	var tests = []struct {
+		description string
+		method      string
+		url         string
+		status      int
+		count       int
+	}{
+		{"all", http.MethodGet, "/api/v1/deployments", http.StatusOK, 2},
+		{"unix_range", http.MethodGet, "/api/v1/deployments?from=1626600100&to=1626600300", http.StatusOK, 1},
+		{"rfc3339_range", http.MethodGet, "/api/v1/deployments?from=2021-07-18T09:20:00Z", http.StatusOK, 2},
+		{"no_match", http.MethodGet, "/api/v1/deployments?team=team-c", http.StatusOK, 0},
+		{"invalid_time", http.MethodGet, "/api/v1/deployments?from=yesterday", http.StatusBadRequest, 0},
+		{"invalid_outcome", http.MethodGet, "/api/v1/deployments?outcome=open", http.StatusBadRequest, 0},
+		{"wrong_method", http.MethodPost, "/api/v1/deployments", http.StatusMethodNotAllowed, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(test.method, test.url, nil))
+			if recorder.Code != test.status {
+				t.Fatalf("Unexpected status %d; expected %d", recorder.Code, test.status)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+			body, _ := ioutil.ReadAll(recorder.Body)
+			var deployments []DeploymentRecord
+			if err := json.Unmarshal(body, &deployments); err != nil {
+				t.Fatalf("Can't parse response %s: %v", body, err)
+			}
+			if len(deployments) != test.count {
+				t.Errorf("Unexpected number of deployments %d; expected %d", len(deployments), test.count)
+			}
+		})
+	}
+}
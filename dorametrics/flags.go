@@ -1,7 +1,13 @@
 package dorametrics
 
-import "strings"
+import (
+	"strings"
 
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// getDeploymentFlags classifies a deployment by its outcome, whether it
+// changed the image and how it relates to the previous deployment
 func getDeploymentFlags(success bool, previousSuccess bool, imageChanged bool) string {
 	var flags []string
 
@@ -38,3 +44,26 @@ func getDeploymentFlags(success bool, previousSuccess bool, imageChanged bool) s
 
 	return strings.Join(flags, "|")
 }
+
+// flagLabels turns deployment flags into the classification labels of
+// dora_deployment_events_total
+func flagLabels(flags string) prometheus.Labels {
+	set := map[string]bool{}
+	for _, flag := range strings.Split(flags, "|") {
+		set[flag] = true
+	}
+
+	labels := prometheus.Labels{"outcome": "failure", "image_change": "false", "transition": "none"}
+	if set["DORA_SUCCESS"] {
+		labels["outcome"] = "success"
+	}
+	if set["DORA_NEW_IMAGE"] {
+		labels["image_change"] = "true"
+	}
+	if set["DORA_RECOVERY"] {
+		labels["transition"] = "recovery"
+	} else if set["DORA_REPEAT_FAILURE"] {
+		labels["transition"] = "repeat_failure"
+	}
+	return labels
+}
@@ -27,3 +27,27 @@ func TestGetDeploymentFlags(t *testing.T) {
 		})
 	}
 }
+
+func TestFlagLabels(t *testing.T) {
+	var tests = []struct {
+		description string
+		flags       string
+		outcome     string
+		imageChange string
+		transition  string
+	}{
+		{"successNewDeployment", "DORA_SUCCESS|DORA_NEW_IMAGE|DORA_SUCCESSFUL_DEPLOYMENT|DORA_PREVIOUS_SUCCESS", "success", "true", "none"},
+		{"failureRepeatDeployment", "DORA_FAILURE|DORA_SAME_IMAGE|DORA_PREVIOUS_SUCCESS", "failure", "false", "none"},
+		{"recovery", "DORA_SUCCESS|DORA_SAME_IMAGE|DORA_PREVIOUS_FAILURE|DORA_RECOVERY", "success", "false", "recovery"},
+		{"repeatFailure", "DORA_FAILURE|DORA_NEW_IMAGE|DORA_FAILED_DEPLOYMENT|DORA_PREVIOUS_FAILURE|DORA_REPEAT_FAILURE", "failure", "true", "repeat_failure"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			labels := flagLabels(test.flags)
+			if labels["outcome"] != test.outcome || labels["image_change"] != test.imageChange || labels["transition"] != test.transition {
+				t.Errorf("Unexpected labels %v for flags %s; expected outcome=%s, image_change=%s, transition=%s", labels, test.flags, test.outcome, test.imageChange, test.transition)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package dorametrics
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	au "github.com/logrusorgru/aurora"
+)
+
+const pagerDutySource = "pagerduty"
+const pagerDutySignatureHeader = "X-PagerDuty-Signature"
+const opsgenieSource = "opsgenie"
+
+// pagerDutyWebhook holds the fields of a PagerDuty v3 webhook we use
+type pagerDutyWebhook struct {
+	Event struct {
+		EventType  string    `json:"event_type"`
+		OccurredAt time.Time `json:"occurred_at"`
+		Data       struct {
+			ID          string `json:"id"`
+			IncidentKey string `json:"incident_key"` // the dedup key of incidents opened through the Events API
+			Service     struct {
+				ID      string `json:"id"`
+				Summary string `json:"summary"`
+			} `json:"service"`
+		} `json:"data"`
+	} `json:"event"`
+}
+
+// PagerDutyHandler receives PagerDuty v3 webhooks signed with the
+// subscription's secret: a triggered incident opens an incident on the
+// service of the same name, and its resolution reports time to recovery
+type PagerDutyHandler struct {
+	Controller *Controller
+	Secret     string
+}
+
+func (h *PagerDutyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, ok := readIncidentWebhook(w, r)
+	if !ok {
+		return
+	}
+	if !validPagerDutySignature(r.Header.Get(pagerDutySignatureHeader), body, h.Secret) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	webhook := pagerDutyWebhook{}
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		http.Error(w, fmt.Sprintf("can't parse PagerDuty webhook: %v", err), http.StatusBadRequest)
+		return
+	}
+	event := webhook.Event
+	if len(event.Data.ID) == 0 {
+		http.Error(w, "incident id is required", http.StatusBadRequest)
+		return
+	}
+
+	// our own pages are outages we've already counted
+	if strings.HasPrefix(event.Data.IncidentKey, alertDedupPrefix) {
+		if h.Controller.Debug {
+			log.Println(fmt.Sprintf("%s: ignoring PagerDuty event for our own incident %s", au.Bold(au.Cyan("INFO")), event.Data.IncidentKey))
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	h.Controller.Mutex.Lock()
+	defer h.Controller.Mutex.Unlock()
+	at := eventUnix(event.OccurredAt)
+	switch event.EventType {
+	case "incident.triggered", "incident.reopened":
+		service := firstNonEmpty(event.Data.Service.Summary, event.Data.Service.ID)
+		if _, _, ok := h.Controller.serviceWorkload(service, ""); !ok {
+			service = event.Data.Service.ID
+		}
+		h.Controller.openServiceIncident(pagerDutySource, event.Data.ID, service, "", at, nil)
+	case "incident.resolved":
+		h.Controller.resolveIncident(pagerDutySource, event.Data.ID, at)
+	default:
+		if h.Controller.Debug {
+			log.Println(fmt.Sprintf("%s: ignoring PagerDuty event of type %s", au.Bold(au.Cyan("INFO")), event.EventType))
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// validPagerDutySignature checks the "v1=<hex>" HMAC-SHA256 signatures of
+// body; there may be several while PagerDuty rotates the secret
+func validPagerDutySignature(header string, body []byte, secret string) bool {
+	if len(secret) == 0 {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+	for _, signature := range strings.Split(header, ",") {
+		signature = strings.TrimSpace(signature)
+		if !strings.HasPrefix(signature, "v1=") {
+			continue
+		}
+		decoded, err := hex.DecodeString(strings.TrimPrefix(signature, "v1="))
+		if err == nil && hmac.Equal(decoded, expected) {
+			return true
+		}
+	}
+	return false
+}
+
+// opsgenieWebhook holds the fields of an Opsgenie alert webhook we use
+type opsgenieWebhook struct {
+	Action string `json:"action"`
+	Alert  struct {
+		AlertID   string            `json:"alertId"`
+		Entity    string            `json:"entity"`
+		Tags      []string          `json:"tags"`
+		Details   map[string]string `json:"details"`
+		CreatedAt int64             `json:"createdAt"` // unix milliseconds
+		UpdatedAt int64             `json:"updatedAt"` // unix milliseconds
+	} `json:"alert"`
+}
+
+// detail returns an alert detail, or the value of a "name:value" tag
+func (webhook opsgenieWebhook) detail(name string) string {
+	if value := webhook.Alert.Details[name]; len(value) > 0 {
+		return value
+	}
+	for _, tag := range webhook.Alert.Tags {
+		if strings.HasPrefix(tag, name+":") {
+			return strings.TrimPrefix(tag, name+":")
+		}
+	}
+	return ""
+}
+
+// OpsgenieHandler receives Opsgenie alert webhooks. Opsgenie doesn't sign
+// them, so the integration sends the secret as a bearer token in a custom
+// Authorization header. A created alert opens an incident on the service
+// named by its service detail or tag, or else its entity, and closing the
+// alert reports time to recovery.
+type OpsgenieHandler struct {
+	Controller *Controller
+	Secret     string
+}
+
+func (h *OpsgenieHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, ok := readIncidentWebhook(w, r)
+	if !ok {
+		return
+	}
+	if !authorized(r, body, h.Secret, "") {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	webhook := opsgenieWebhook{}
+	if err := json.Unmarshal(body, &webhook); err != nil {
+		http.Error(w, fmt.Sprintf("can't parse Opsgenie webhook: %v", err), http.StatusBadRequest)
+		return
+	}
+	if len(webhook.Alert.AlertID) == 0 {
+		http.Error(w, "alert id is required", http.StatusBadRequest)
+		return
+	}
+
+	h.Controller.Mutex.Lock()
+	defer h.Controller.Mutex.Unlock()
+	switch webhook.Action {
+	case "Create":
+		service := firstNonEmpty(webhook.detail("service"), webhook.Alert.Entity)
+		labels := map[string]string{"team": webhook.detail("team")}
+		start := eventUnix(time.UnixMilli(webhook.Alert.CreatedAt))
+		h.Controller.openServiceIncident(opsgenieSource, webhook.Alert.AlertID, service, webhook.detail("namespace"), start, labels)
+	case "Close":
+		end := eventUnix(time.UnixMilli(webhook.Alert.UpdatedAt))
+		h.Controller.resolveIncident(opsgenieSource, webhook.Alert.AlertID, end)
+	default:
+		if h.Controller.Debug {
+			log.Println(fmt.Sprintf("%s: ignoring Opsgenie action %s", au.Bold(au.Cyan("INFO")), webhook.Action))
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func readIncidentWebhook(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, false
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		http.Error(w, "can't read request body", http.StatusBadRequest)
+		return nil, false
+	}
+	return body, true
+}
+
+// eventUnix returns the time in unix seconds, or now if it's unset or in the future
+func eventUnix(at time.Time) int64 {
+	now := time.Now().Unix()
+	if at.Unix() <= 0 || at.Unix() > now {
+		return now
+	}
+	return at.Unix()
+}
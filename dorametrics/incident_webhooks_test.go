@@ -0,0 +1,187 @@
+package dorametrics
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func postWebhook(handler http.Handler, header string, value string, body string) int {
+	request := httptest.NewRequest(http.MethodPost, "/api/v1/webhooks", strings.NewReader(body))
+	request.Header.Set(header, value)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder.Code
+}
+
+func pagerDutySignature(body string, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestPagerDutyHandler(t *testing.T) {
+	c := newTestController(t)
+	key := "Deployment/default/server-a"
+	c.State[key] = DeploymentInfo{Name: "server-a", Namespace: "default", Kind: KindDeployment, LastDeployedAt: 1646128800}
+	c.DefaultChangeFailureWindow = time.Hour
+	handler := &PagerDutyHandler{Controller: c, Secret: "s3cret"}
+	labels := c.metricLabels("server-a", "default", KindDeployment)
+
+	triggered := `{"event":{"id":"01","event_type":"incident.triggered","occurred_at":"2022-03-01T10:00:00Z",
+		"data":{"id":"PGR0VU2","service":{"id":"PF9KMXH","summary":"server-a"}}}}`
+	reopened := strings.Replace(triggered, "incident.triggered", "incident.reopened", 1)
+	resolved := `{"event":{"id":"02","event_type":"incident.resolved","occurred_at":"2022-03-01T10:20:00Z",
+		"data":{"id":"PGR0VU2","service":{"id":"PF9KMXH","summary":"server-a"}}}}`
+
+	if status := postWebhook(handler, pagerDutySignatureHeader, pagerDutySignature(triggered, "guess"), triggered); status != http.StatusUnauthorized {
+		t.Errorf("Unexpected status %d for a wrong signature; expected %d", status, http.StatusUnauthorized)
+	}
+	// PagerDuty sends a signature per secret while rotating them
+	signatures := pagerDutySignature(triggered, "old") + "," + pagerDutySignature(triggered, "s3cret")
+	if status := postWebhook(handler, pagerDutySignatureHeader, signatures, triggered); status != http.StatusAccepted {
+		t.Fatalf("Unexpected status %d for a triggered incident", status)
+	}
+	if status := postWebhook(handler, pagerDutySignatureHeader, pagerDutySignature(reopened, "s3cret"), reopened); status != http.StatusAccepted {
+		t.Fatalf("Unexpected status %d for a reopened incident", status)
+	}
+	if len(c.Incidents) != 1 {
+		t.Fatalf("Unexpected incidents %+v; expected one", c.Incidents)
+	}
+	if value := testutil.ToFloat64(c.Collectors.ChangeFailureCounter.With(withSource(labels, pagerDutySource))); value != 1 {
+		t.Errorf("Unexpected change failure count %v; expected 1", value)
+	}
+	if c.State[key].ChangeFailureAt != 1646128800 {
+		t.Errorf("Unexpected change failure time %d", c.State[key].ChangeFailureAt)
+	}
+
+	if status := postWebhook(handler, pagerDutySignatureHeader, pagerDutySignature(resolved, "s3cret"), resolved); status != http.StatusAccepted {
+		t.Fatalf("Unexpected status %d for a resolved incident", status)
+	}
+	if len(c.Incidents) != 0 {
+		t.Errorf("Expected no open incidents; got %+v", c.Incidents)
+	}
+	recoveryLabels := withSource(withState(labels, availabilityDown), pagerDutySource)
+	if value := testutil.ToFloat64(c.Collectors.TimeToRecoveryGauge.With(recoveryLabels)); value != 1200 {
+		t.Errorf("Unexpected time to recovery %v; expected 1200", value)
+	}
+}
+
+func TestOpsgenieHandler(t *testing.T) {
+	c := newTestController(t)
+	c.State["Deployment/default/server-a"] = DeploymentInfo{Name: "server-a", Namespace: "default", Kind: KindDeployment, LastDeployedAt: 1646128800}
+	c.State["Deployment/staging/server-a"] = DeploymentInfo{Name: "server-a", Namespace: "staging", Kind: KindDeployment, LastDeployedAt: 1646130000}
+	c.DefaultChangeFailureWindow = time.Hour
+	handler := &OpsgenieHandler{Controller: c, Secret: "s3cret"}
+	labels := c.metricLabels("server-a", "default", KindDeployment)
+
+	created := `{"action":"Create","alert":{"alertId":"70413a06","tags":["service:server-a","namespace:default"],
+		"createdAt":1646128900000,"updatedAt":1646128900000}}`
+	closed := `{"action":"Close","alert":{"alertId":"70413a06","createdAt":1646128900000,"updatedAt":1646129500000}}`
+	unknown := `{"action":"Create","alert":{"alertId":"9c6e0a7b","entity":"billing","createdAt":1646128900000}}`
+
+	if status := postWebhook(handler, "Authorization", "Bearer guess", created); status != http.StatusUnauthorized {
+		t.Errorf("Unexpected status %d for a wrong token; expected %d", status, http.StatusUnauthorized)
+	}
+	if status := postWebhook(handler, "Authorization", "Bearer s3cret", unknown); status != http.StatusAccepted {
+		t.Errorf("Unexpected status %d for an alert on an unknown service", status)
+	}
+	if status := postWebhook(handler, "Authorization", "Bearer s3cret", created); status != http.StatusAccepted {
+		t.Fatalf("Unexpected status %d for a created alert", status)
+	}
+	if len(c.Incidents) != 1 {
+		t.Fatalf("Unexpected incidents %+v; expected one", c.Incidents)
+	}
+	// the namespace tag picks the workload, though the other was deployed more recently
+	if value := testutil.ToFloat64(c.Collectors.ChangeFailureCounter.With(withSource(labels, opsgenieSource))); value != 1 {
+		t.Errorf("Unexpected change failure count %v; expected 1", value)
+	}
+
+	if status := postWebhook(handler, "Authorization", "Bearer s3cret", closed); status != http.StatusAccepted {
+		t.Fatalf("Unexpected status %d for a closed alert", status)
+	}
+	recoveryLabels := withSource(withState(labels, availabilityDown), opsgenieSource)
+	if value := testutil.ToFloat64(c.Collectors.TimeToRecoveryGauge.With(recoveryLabels)); value != 600 {
+		t.Errorf("Unexpected time to recovery %v; expected 600", value)
+	}
+}
+
+func TestPagerDutyHandlerAttribution(t *testing.T) {
+	var tests = []struct {
+		description    string
+		incidentKey    string
+		lastDeployedAt int64
+		window         time.Duration
+		incident       bool
+		changeFailure  bool
+	}{
+		{"within_window", "", 1646128800 - 600, time.Hour, true, true},
+		{"after_window", "", 1646128800 - 7200, time.Hour, true, false},
+		{"window_disabled", "", 1646128800 - 600, 0, true, false},
+		{"own_page", "dora-metrics/Deployment/default/server-a", 1646128800 - 600, time.Hour, false, false},
+		{"other_events_api_page", "checkout-errors", 1646128800 - 600, time.Hour, true, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			c := newTestController(t)
+			c.State["Deployment/default/server-a"] = DeploymentInfo{Name: "server-a", Namespace: "default", Kind: KindDeployment, LastDeployedAt: test.lastDeployedAt}
+			c.DefaultChangeFailureWindow = test.window
+			handler := &PagerDutyHandler{Controller: c, Secret: "s3cret"}
+			labels := withSource(c.metricLabels("server-a", "default", KindDeployment), pagerDutySource)
+
+			triggered := `{"event":{"id":"01","event_type":"incident.triggered","occurred_at":"2022-03-01T10:00:00Z",
+				"data":{"id":"PGR0VU2","incident_key":"` + test.incidentKey + `","service":{"id":"PF9KMXH","summary":"server-a"}}}}`
+			if status := postWebhook(handler, pagerDutySignatureHeader, pagerDutySignature(triggered, "s3cret"), triggered); status != http.StatusAccepted {
+				t.Fatalf("Unexpected status %d for a triggered incident", status)
+			}
+			if incident := len(c.Incidents) == 1; incident != test.incident {
+				t.Errorf("Unexpected incident=%t; expected %t", incident, test.incident)
+			}
+			if value := testutil.ToFloat64(c.Collectors.ChangeFailureCounter.With(labels)); (value == 1) != test.changeFailure {
+				t.Errorf("Unexpected change failure count %v", value)
+			}
+		})
+	}
+}
+
+func TestMarkChangeFailure(t *testing.T) {
+	var tests = []struct {
+		description     string
+		lastDeployedAt  int64
+		changeFailureAt int64
+		incidentAt      int64
+		counted         bool
+	}{
+		{"deployed_before", 1000, 0, 2000, true},
+		{"never_deployed", 0, 0, 2000, false},
+		{"deployed_after", 3000, 0, 2000, false},
+		{"already_counted", 1000, 1000, 2000, false},
+		{"redeployed", 1500, 1000, 2000, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			c := newTestController(t)
+			info := DeploymentInfo{Name: "server-a", Namespace: "default", Kind: KindDeployment, LastDeployedAt: test.lastDeployedAt, ChangeFailureAt: test.changeFailureAt}
+			if counted := c.markChangeFailure(&info, pagerDutySource, "P1", test.incidentAt); counted != test.counted {
+				t.Errorf("Unexpected counted=%t; expected %t", counted, test.counted)
+			}
+
+			labels := withSource(c.metricLabels("server-a", "default", KindDeployment), pagerDutySource)
+			if value := testutil.ToFloat64(c.Collectors.ChangeFailureCounter.With(labels)); (value == 1) != test.counted {
+				t.Errorf("Unexpected change failure count %v", value)
+			}
+			if test.counted && info.ChangeFailureAt != test.lastDeployedAt {
+				t.Errorf("Unexpected change failure time %d; expected %d", info.ChangeFailureAt, test.lastDeployedAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package dorametrics
+
+import (
+	"fmt"
+	"log"
+	"math"
+
+	au "github.com/logrusorgru/aurora"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// recoverySourcePods marks time to recovery observed on pods rather than
+// reported by incident tooling, whose source is the tool
+const recoverySourcePods = "pods"
+
+// IncidentInfo is an outage reported by incident tooling rather than observed on pods
+type IncidentInfo struct {
+	ID        string            `json:"id"`
+	Source    string            `json:"source"`
+	Service   string            `json:"service"`
+	Namespace string            `json:"namespace"`
+	Kind      string            `json:"kind"`
+	Start     int64             `json:"start"`
+	Labels    map[string]string `json:"labels,omitempty"` // team or service reported by the tool, overriding the workload's
+}
+
+func incidentKey(source string, id string) string {
+	return source + "/" + id
+}
+
+// withSource adds the source of a time to recovery to a copy of the metric labels
+func withSource(metricLabels prometheus.Labels, source string) prometheus.Labels {
+	labels := prometheus.Labels{"source": source}
+	for name, value := range metricLabels {
+		labels[name] = value
+	}
+	return labels
+}
+
+// incidentLabels returns the metric labels of the incident's workload; callers hold c.Mutex
+func (c *Controller) incidentLabels(incident IncidentInfo) prometheus.Labels {
+	metricLabels := c.metricLabels(incident.Service, incident.Namespace, incident.Kind)
+	for _, name := range []string{"team", "service"} {
+		if value := incident.Labels[name]; len(value) > 0 {
+			metricLabels[name] = value
+		}
+	}
+	return metricLabels
+}
+
+// openIncident starts the clock on an incident; callers hold c.Mutex
+func (c *Controller) openIncident(incident IncidentInfo) {
+	key := incidentKey(incident.Source, incident.ID)
+	if _, ok := c.Incidents[key]; ok {
+		if c.Debug {
+			log.Println(fmt.Sprintf("%s: incident %s already open", au.Bold(au.Cyan("INFO")), key))
+		}
+		return
+	}
+
+	log.Println(fmt.Sprintf("%s: opened incident %s for %s %s in namespace %s", au.Bold(au.Cyan("INFO")), au.Bold(key), incident.Kind, au.Bold(incident.Service), au.Bold(incident.Namespace)))
+	c.Incidents[key] = incident
+	metricLabels := c.incidentLabels(incident)
+	c.Collectors.DowntimeCounter.With(withSource(withState(metricLabels, availabilityDown), incident.Source)).Inc()
+	c.recordEvent(EventRecord{
+		Type:       EventOutageOpened,
+		Time:       incident.Start,
+		Kind:       incident.Kind,
+		Namespace:  incident.Namespace,
+		Name:       incident.Service,
+		State:      availabilityDown,
+		IncidentID: incident.ID,
+		Source:     incident.Source,
+	}, metricLabels)
+	c.persistState()
+}
+
+// resolveIncident records time to recovery for an open incident; callers hold c.Mutex
+func (c *Controller) resolveIncident(source string, id string, end int64) {
+	key := incidentKey(source, id)
+	incident, ok := c.Incidents[key]
+	if !ok {
+		log.Println(fmt.Sprintf("%s: ignoring resolution of unknown incident %s", au.Bold(au.Cyan("INFO")), key))
+		return
+	}
+
+	timeToRecovery := end - incident.Start
+	if timeToRecovery < 0 {
+		timeToRecovery = 0
+	}
+	metricLabels := c.incidentLabels(incident)
+	c.recordEvent(EventRecord{
+		Type:            EventOutageClosed,
+		Time:            end,
+		Kind:            incident.Kind,
+		Namespace:       incident.Namespace,
+		Name:            incident.Service,
+		State:           availabilityDown,
+		Start:           incident.Start,
+		DurationSeconds: timeToRecovery,
+		IncidentID:      incident.ID,
+		Source:          incident.Source,
+	}, metricLabels)
+	metricLabels = withSource(withState(metricLabels, availabilityDown), incident.Source)
+	timeToRecovery = c.clampObservation(observationTimeToRecovery, timeToRecovery, incident.Kind, incident.Namespace, incident.Service, metricLabels)
+	log.Println(fmt.Sprintf("%s: resolved incident %s for %s %s in namespace %s: TTR was %d", au.Bold(au.Cyan("INFO")), au.Bold(key), incident.Kind, au.Bold(incident.Service), au.Bold(incident.Namespace), au.Bold(timeToRecovery)))
+	c.Collectors.TimeToRecoveryGauge.With(metricLabels).Set(math.Round(float64(timeToRecovery)))
+	c.Collectors.TimeToRecoveryHistogram.With(metricLabels).Observe(float64(timeToRecovery))
+	delete(c.Incidents, key)
+	c.persistState()
+}
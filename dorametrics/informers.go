@@ -0,0 +1,189 @@
+package dorametrics
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// EnabledLabel marks workloads the controller tracks
+const EnabledLabel = "dora-controller/enabled"
+
+// RolloutResource identifies Argo Rollouts
+var RolloutResource = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "rollouts"}
+
+// kindsByResource maps the plural resource names accepted on the command line to kinds
+var kindsByResource = map[string]string{
+	"deployments":  KindDeployment,
+	"statefulsets": KindStatefulSet,
+	"daemonsets":   KindDaemonSet,
+	"rollouts":     KindRollout,
+}
+
+// ParseKinds turns a comma-separated list of resource names into workload kinds
+func ParseKinds(list string) ([]string, error) {
+	var kinds []string
+	for _, resource := range strings.Split(list, ",") {
+		resource = strings.ToLower(strings.TrimSpace(resource))
+		if len(resource) == 0 {
+			continue
+		}
+		kind, ok := kindsByResource[resource]
+		if !ok {
+			return nil, fmt.Errorf("unsupported resource %s", resource)
+		}
+		kinds = append(kinds, kind)
+	}
+	if len(kinds) == 0 {
+		return nil, fmt.Errorf("no resources in %q", list)
+	}
+	return kinds, nil
+}
+
+// workloadKey prefixes the usual namespace/name key with the kind so that
+// all informers can share one workqueue
+func workloadKey(kind string, obj interface{}) (string, error) {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return "", err
+	}
+	return kind + "/" + key, nil
+}
+
+// splitWorkloadKey reverses workloadKey
+func splitWorkloadKey(key string) (string, string, error) {
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 {
+		return "", "", fmt.Errorf("unexpected key format %q", key)
+	}
+	return parts[0], parts[1], nil
+}
+
+func listWatchForKind(kind string, clientset kubernetes.Interface, dynamicClient dynamic.Interface, optionsModifier func(*metav1.ListOptions)) (*cache.ListWatch, runtime.Object, error) {
+	var list func(metav1.ListOptions) (runtime.Object, error)
+	var watchFunc func(metav1.ListOptions) (watch.Interface, error)
+	var objType runtime.Object
+
+	switch kind {
+	case KindDeployment:
+		client := clientset.AppsV1().Deployments(metav1.NamespaceAll)
+		list = func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.List(context.TODO(), options)
+		}
+		watchFunc = func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.Watch(context.TODO(), options)
+		}
+		objType = &appsv1.Deployment{}
+	case KindStatefulSet:
+		client := clientset.AppsV1().StatefulSets(metav1.NamespaceAll)
+		list = func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.List(context.TODO(), options)
+		}
+		watchFunc = func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.Watch(context.TODO(), options)
+		}
+		objType = &appsv1.StatefulSet{}
+	case KindDaemonSet:
+		client := clientset.AppsV1().DaemonSets(metav1.NamespaceAll)
+		list = func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.List(context.TODO(), options)
+		}
+		watchFunc = func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.Watch(context.TODO(), options)
+		}
+		objType = &appsv1.DaemonSet{}
+	case KindRollout:
+		if dynamicClient == nil {
+			return nil, nil, fmt.Errorf("watching rollouts requires a dynamic client")
+		}
+		client := dynamicClient.Resource(RolloutResource).Namespace(metav1.NamespaceAll)
+		list = func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.List(context.TODO(), options)
+		}
+		watchFunc = func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.Watch(context.TODO(), options)
+		}
+		objType = &unstructured.Unstructured{}
+	default:
+		return nil, nil, fmt.Errorf("unsupported kind %s", kind)
+	}
+
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			optionsModifier(&options)
+			return list(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			optionsModifier(&options)
+			return watchFunc(options)
+		},
+	}, objType, nil
+}
+
+// NewWorkloadInformers sets up one informer per kind, all feeding the same workqueue
+func NewWorkloadInformers(
+	clientset kubernetes.Interface,
+	dynamicClient dynamic.Interface,
+	kinds []string,
+	labelSelector string,
+	queue workqueue.RateLimitingInterface) (map[string]cache.Indexer, []cache.Controller, error) {
+	optionsModifier := func(options *metav1.ListOptions) {
+		options.LabelSelector = labelSelector
+	}
+
+	indexers := map[string]cache.Indexer{}
+	var informers []cache.Controller
+	for _, kind := range kinds {
+		kind := kind
+		listWatcher, objType, err := listWatchForKind(kind, clientset, dynamicClient, optionsModifier)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		enqueue := func(obj interface{}) {
+			key, err := workloadKey(kind, obj)
+			if err == nil {
+				queue.Add(key)
+			}
+		}
+
+		indexer, informer := cache.NewIndexerInformer(listWatcher, objType, 0, cache.ResourceEventHandlerFuncs{
+			AddFunc: enqueue,
+			UpdateFunc: func(old interface{}, new interface{}) {
+				enqueue(new)
+			},
+			DeleteFunc: enqueue,
+		}, cache.Indexers{})
+
+		indexers[kind] = indexer
+		informers = append(informers, informer)
+	}
+
+	return indexers, informers, nil
+}
+
+// NewNamespaceInformer caches namespaces so their labels can feed metric labels
+func NewNamespaceInformer(clientset kubernetes.Interface) (cache.Indexer, cache.Controller) {
+	client := clientset.CoreV1().Namespaces()
+	listWatcher := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return client.List(context.TODO(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return client.Watch(context.TODO(), options)
+		},
+	}
+	return cache.NewIndexerInformer(listWatcher, &corev1.Namespace{}, 0, cache.ResourceEventHandlerFuncs{}, cache.Indexers{})
+}
@@ -0,0 +1,123 @@
+package dorametrics
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+var enabledLabels = map[string]string{"dora-controller/enabled": "true"}
+
+func int32Ptr(i int32) *int32 { return &i }
+
+func rollout(name string, replicas int64, readyReplicas int64) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "argoproj.io/v1alpha1",
+		"kind":       "Rollout",
+		"metadata": map[string]interface{}{
+			"name":      name,
+			"namespace": "default",
+			"labels":    map[string]interface{}{"dora-controller/enabled": "true"},
+		},
+		"spec":   map[string]interface{}{"replicas": replicas},
+		"status": map[string]interface{}{"readyReplicas": readyReplicas},
+	}}
+}
+
+func TestNewWorkloadInformers(t *testing.T) {
+	clientset := fake.NewSimpleClientset(
+		&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "server-a", Namespace: "default", Labels: enabledLabels}, Spec: appsv1.DeploymentSpec{Replicas: int32Ptr(2)}, Status: appsv1.DeploymentStatus{ReadyReplicas: 1}},
+		&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default", Labels: enabledLabels}, Spec: appsv1.StatefulSetSpec{Replicas: int32Ptr(3)}, Status: appsv1.StatefulSetStatus{ReadyReplicas: 3}},
+		&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "default", Labels: enabledLabels}, Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 4, NumberReady: 2}},
+	)
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{RolloutResource: "RolloutList"},
+		rollout("canary", 5, 4))
+
+	kinds, err := ParseKinds("deployments, statefulsets,daemonsets,rollouts")
+	if err != nil {
+		t.Fatalf("Unexpected error parsing kinds: %v", err)
+	}
+
+	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer queue.ShutDown()
+	indexers, informers, err := NewWorkloadInformers(clientset, dynamicClient, kinds, "dora-controller/enabled=true", queue)
+	if err != nil {
+		t.Fatalf("Unexpected error creating informers: %v", err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	var synced []cache.InformerSynced
+	for _, informer := range informers {
+		go informer.Run(stop)
+		synced = append(synced, informer.HasSynced)
+	}
+	if !cache.WaitForCacheSync(stop, synced...) {
+		t.Fatalf("Timed out waiting for caches to sync")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for queue.Len() < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var tests = []struct {
+		key      string
+		desired  int32
+		ready    int32
+		expected string
+	}{
+		{"DaemonSet/default/agent", 4, 2, KindDaemonSet},
+		{"Deployment/default/server-a", 2, 1, KindDeployment},
+		{"Rollout/default/canary", 5, 4, KindRollout},
+		{"StatefulSet/default/db", 3, 3, KindStatefulSet},
+	}
+
+	var keys []string
+	for queue.Len() > 0 {
+		key, _ := queue.Get()
+		keys = append(keys, key.(string))
+		queue.Done(key)
+	}
+	sort.Strings(keys)
+	if len(keys) != len(tests) {
+		t.Fatalf("Unexpected queue keys %v", keys)
+	}
+
+	for i, test := range tests {
+		t.Run(test.key, func(t *testing.T) {
+			if keys[i] != test.key {
+				t.Fatalf("Unexpected key %s; expected %s", keys[i], test.key)
+			}
+			kind, metaKey, err := splitWorkloadKey(test.key)
+			if err != nil {
+				t.Fatalf("Unexpected error splitting key: %v", err)
+			}
+			obj, exists, err := indexers[kind].GetByKey(metaKey)
+			if err != nil || !exists {
+				t.Fatalf("Workload %s not found in indexer", test.key)
+			}
+			workload, err := asWorkload(obj)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if workload.Kind() != test.expected {
+				t.Errorf("Unexpected kind %s; expected %s", workload.Kind(), test.expected)
+			}
+			if workload.DesiredReplicas() != test.desired || workload.ReadyReplicas() != test.ready {
+				t.Errorf("Unexpected replicas %d/%d; expected %d/%d", workload.ReadyReplicas(), workload.DesiredReplicas(), test.ready, test.desired)
+			}
+		})
+	}
+}
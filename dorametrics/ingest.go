@@ -0,0 +1,173 @@
+package dorametrics
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	au "github.com/logrusorgru/aurora"
+)
+
+const signatureHeader = "X-Dora-Signature"
+const signaturePrefix = "sha256="
+const timestampHeader = "X-Dora-Timestamp"
+const maxRequestBytes = 1 << 20
+
+// ingestDedupSuffix marks the Dedup entries that hold the identity of the
+// last event ingested for a workload, next to its report-before entry
+const ingestDedupSuffix = "#ingest"
+
+// maxSignatureAge bounds how far a signed request's timestamp may be from
+// now, so that a captured request can't be replayed later
+const maxSignatureAge = 5 * time.Minute
+
+// IngestHandler accepts deployment events posted by CI pipelines for tracked
+// workloads; requests must carry the shared secret as a bearer token or an
+// HMAC-SHA256 signature of a timestamp and the body keyed with HMACKey
+type IngestHandler struct {
+	Controller *Controller
+	Secret     string
+	HMACKey    string
+}
+
+func (h *IngestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	if err != nil {
+		http.Error(w, "can't read request body", http.StatusBadRequest)
+		return
+	}
+
+	if !authorized(r, body, h.Secret, h.HMACKey) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	event := DeploymentEvent{}
+	err = json.Unmarshal(body, &event)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("can't parse deployment event: %v", err), http.StatusBadRequest)
+		return
+	}
+	err = validateDeploymentEvent(&event)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	log.Println(fmt.Sprintf("%s: received deployment event for %s %s in namespace %s", au.Bold(au.Cyan("INFO")), event.Kind, au.Bold(event.Service), au.Bold(event.Namespace)))
+	h.Controller.Mutex.Lock()
+	defer h.Controller.Mutex.Unlock()
+	if !h.Controller.trackedWorkload(event.Kind, event.Namespace, event.Service) {
+		http.Error(w, fmt.Sprintf("%s %s in namespace %s is not tracked", event.Kind, event.Service, event.Namespace), http.StatusNotFound)
+		return
+	}
+
+	// a CI pipeline that didn't see our answer will send the event again
+	dedupKey := event.Kind + "/" + event.Namespace + "/" + event.Service + ingestDedupSuffix
+	identity := event.identity()
+	if len(identity) > 0 && h.Controller.Dedup[dedupKey] == identity {
+		log.Println(fmt.Sprintf("%s: ignoring repeated deployment event %s for %s %s in namespace %s", au.Bold(au.Cyan("INFO")), identity, event.Kind, au.Bold(event.Service), au.Bold(event.Namespace)))
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	h.Controller.reportDeployment(event)
+	if len(identity) > 0 {
+		h.Controller.Dedup[dedupKey] = identity
+		h.Controller.persistState()
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// identity tells a retried event from a new deployment: its id, or else its
+// commit and finish time; events with neither can't be told apart
+func (event DeploymentEvent) identity() string {
+	if len(event.ID) > 0 {
+		return "id:" + event.ID
+	}
+	if len(event.CommitSHA) > 0 && event.FinishedAt > 0 {
+		return fmt.Sprintf("%s@%d", event.CommitSHA, event.FinishedAt)
+	}
+	return ""
+}
+
+// dedupWorkloadKey returns the workload key of a Dedup entry
+func dedupWorkloadKey(key string) string {
+	return strings.TrimSuffix(key, ingestDedupSuffix)
+}
+
+// authorized accepts a request that satisfies any configured mechanism
+func authorized(r *http.Request, body []byte, secret string, hmacKey string) bool {
+	if len(secret) > 0 {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) == 1 {
+			return true
+		}
+	}
+	if len(hmacKey) > 0 {
+		return validSignature(r.Header.Get(signatureHeader), r.Header.Get(timestampHeader), body, hmacKey, time.Now())
+	}
+	return false
+}
+
+// validSignature checks a "sha256=<hex>" HMAC signature of the timestamp
+// header (unix seconds), a dot and body, made within maxSignatureAge of now
+func validSignature(signature string, timestamp string, body []byte, key string, now time.Time) bool {
+	if !strings.HasPrefix(signature, signaturePrefix) {
+		return false
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil {
+		return false
+	}
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if age := now.Sub(time.Unix(signedAt, 0)); age > maxSignatureAge || age < -maxSignatureAge {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// trackedWorkload tells whether the controller tracks the workload, so that
+// reports about anything else create neither state nor series; callers hold
+// c.Mutex
+func (c *Controller) trackedWorkload(kind string, namespace string, name string) bool {
+	workload := c.lookupWorkload(kind, namespace, name)
+	return workload != nil && c.tracked(kind, namespace, name, workload.GetLabels())
+}
+
+func validateDeploymentEvent(event *DeploymentEvent) error {
+	if len(event.Service) == 0 {
+		return fmt.Errorf("service is required")
+	}
+	if len(event.Namespace) == 0 {
+		return fmt.Errorf("namespace is required")
+	}
+	if len(event.Kind) == 0 {
+		event.Kind = KindDeployment
+	}
+	if event.CycleTimeSeconds < 0 {
+		return fmt.Errorf("cycleTimeSeconds must not be negative")
+	}
+	return nil
+}
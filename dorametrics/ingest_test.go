@@ -0,0 +1,138 @@
+package dorametrics
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func sign(timestamp string, body string, key string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(timestamp + "." + body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestIngestHandler(t *testing.T) {
+	success := `{"service":"server-a","namespace":"default","success":true,"cycleTimeSeconds":300,"commitSha":"abc123"}`
+	failure := `{"service":"server-a","namespace":"default","success":false}`
+	untracked := `{"service":"server-b","namespace":"default","success":true}`
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+
+	var tests = []struct {
+		description string
+		method      string
+		body        string
+		headers     map[string]string
+		status      int
+		successes   float64
+		failures    float64
+	}{
+		{"bearer_token", http.MethodPost, success, map[string]string{"Authorization": "Bearer s3cret"}, http.StatusAccepted, 1, 0},
+		{"hmac_signature", http.MethodPost, failure, map[string]string{signatureHeader: sign(now, failure, "k3y"), timestampHeader: now}, http.StatusAccepted, 0, 1},
+		{"wrong_token", http.MethodPost, success, map[string]string{"Authorization": "Bearer guess"}, http.StatusUnauthorized, 0, 0},
+		{"wrong_signature", http.MethodPost, success, map[string]string{signatureHeader: sign(now, failure, "k3y"), timestampHeader: now}, http.StatusUnauthorized, 0, 0},
+		{"replayed_signature", http.MethodPost, failure, map[string]string{signatureHeader: sign(stale, failure, "k3y"), timestampHeader: stale}, http.StatusUnauthorized, 0, 0},
+		{"unsigned_timestamp", http.MethodPost, failure, map[string]string{signatureHeader: sign(stale, failure, "k3y"), timestampHeader: now}, http.StatusUnauthorized, 0, 0},
+		{"untracked_workload", http.MethodPost, untracked, map[string]string{"Authorization": "Bearer s3cret"}, http.StatusNotFound, 0, 0},
+		{"no_credentials", http.MethodPost, success, nil, http.StatusUnauthorized, 0, 0},
+		{"missing_namespace", http.MethodPost, `{"service":"server-a","success":true}`, map[string]string{"Authorization": "Bearer s3cret"}, http.StatusBadRequest, 0, 0},
+		{"malformed", http.MethodPost, `{"service":`, map[string]string{"Authorization": "Bearer s3cret"}, http.StatusBadRequest, 0, 0},
+		{"wrong_method", http.MethodGet, "", map[string]string{"Authorization": "Bearer s3cret"}, http.StatusMethodNotAllowed, 0, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			c := newTestController(t)
+			c.Indexers[KindDeployment].Add(deployment("server-a", 2, 2, nil))
+			unlabelled := deployment("server-b", 2, 2, nil)
+			unlabelled.Labels = nil
+			c.Indexers[KindDeployment].Add(unlabelled)
+			handler := &IngestHandler{Controller: c, Secret: "s3cret", HMACKey: "k3y"}
+
+			request := httptest.NewRequest(test.method, "/api/v1/deployments", strings.NewReader(test.body))
+			for name, value := range test.headers {
+				request.Header.Set(name, value)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Errorf("Unexpected status %d; expected %d", recorder.Code, test.status)
+			}
+			labels := c.metricLabels("server-a", "default", KindDeployment)
+			if value := testutil.ToFloat64(c.Collectors.SuccessCounter.With(labels)); value != test.successes {
+				t.Errorf("Unexpected success count %v; expected %v", value, test.successes)
+			}
+			if value := testutil.ToFloat64(c.Collectors.FailureCounter.With(labels)); value != test.failures {
+				t.Errorf("Unexpected failure count %v; expected %v", value, test.failures)
+			}
+			if _, ok := c.State["Deployment/default/server-b"]; ok {
+				t.Errorf("Unexpected state for an untracked workload")
+			}
+		})
+	}
+}
+
+func TestIngestRetry(t *testing.T) {
+	var tests = []struct {
+		description string
+		bodies      []string
+		successes   float64
+	}{
+		{"same_id", []string{
+			`{"id":"pipeline-1","service":"server-a","namespace":"default","success":true}`,
+			`{"id":"pipeline-1","service":"server-a","namespace":"default","success":true}`,
+		}, 1},
+		{"same_commit_and_finish", []string{
+			`{"service":"server-a","namespace":"default","success":true,"commitSha":"abc123","finishedAt":1626600000}`,
+			`{"service":"server-a","namespace":"default","success":true,"commitSha":"abc123","finishedAt":1626600000}`,
+		}, 1},
+		{"redeployed_commit", []string{
+			`{"service":"server-a","namespace":"default","success":true,"commitSha":"abc123","finishedAt":1626600000}`,
+			`{"service":"server-a","namespace":"default","success":true,"commitSha":"abc123","finishedAt":1626603600}`,
+		}, 2},
+		{"no_identity", []string{
+			`{"service":"server-a","namespace":"default","success":true}`,
+			`{"service":"server-a","namespace":"default","success":true}`,
+		}, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			c := newTestController(t)
+			c.Indexers[KindDeployment].Add(deployment("server-a", 2, 2, nil))
+			if err := c.syncToStdout("Deployment/default/server-a"); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			handler := &IngestHandler{Controller: c, Secret: "s3cret"}
+			for _, body := range test.bodies {
+				request := httptest.NewRequest(http.MethodPost, "/api/v1/deployments", strings.NewReader(body))
+				request.Header.Set("Authorization", "Bearer s3cret")
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, request)
+				if recorder.Code != http.StatusAccepted {
+					t.Fatalf("Unexpected status %d; expected %d", recorder.Code, http.StatusAccepted)
+				}
+			}
+			labels := c.metricLabels("server-a", "default", KindDeployment)
+			if value := testutil.ToFloat64(c.Collectors.SuccessCounter.With(labels)); value != test.successes {
+				t.Errorf("Unexpected success count %v; expected %v", value, test.successes)
+			}
+
+			// the last identity is saved with the workload's state
+			c.pruneState(time.Now().Unix())
+			if _, ok := c.Dedup["Deployment/default/server-a"+ingestDedupSuffix]; ok != (test.description != "no_identity") {
+				t.Errorf("Unexpected dedup entries %+v", c.Dedup)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package dorametrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+)
+
+const teamKey = "dora-controller/team"
+const serviceKey = "dora-controller/service"
+const environmentKey = "dora-controller/environment"
+
+// defaultLabelValue is used when no team or environment can be found
+const defaultLabelValue = "unknown"
+
+// metadataValue returns the first non-empty annotation or label for key
+func metadataValue(annotations map[string]string, labels map[string]string, key string) string {
+	if value := annotations[key]; len(value) > 0 {
+		return value
+	}
+	return labels[key]
+}
+
+// firstNonEmpty returns the first non-empty value
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if len(value) > 0 {
+			return value
+		}
+	}
+	return ""
+}
+
+// metricLabels returns the labels identifying a workload on every DORA metric;
+// callers hold c.Mutex
+//
+// team, service and environment are looked up in order on the workload
+// (annotations, then labels), on the matching configuration target, on the
+// namespace and finally fall back to configurable defaults (service falls back
+// to the workload name)
+func (c *Controller) metricLabels(name string, namespace string, kind string) prometheus.Labels {
+	var workloadAnnotations, workloadLabels, namespaceLabels map[string]string
+	if indexer, ok := c.Indexers[kind]; ok {
+		if obj, exists, err := indexer.GetByKey(namespace + "/" + name); err == nil && exists {
+			if accessor, err := meta.Accessor(obj); err == nil {
+				workloadAnnotations = accessor.GetAnnotations()
+				workloadLabels = accessor.GetLabels()
+			}
+		}
+	}
+	if c.Namespaces != nil {
+		if obj, exists, err := c.Namespaces.GetByKey(namespace); err == nil && exists {
+			namespaceLabels = obj.(*corev1.Namespace).GetLabels()
+		}
+	}
+
+	target := Target{}
+	stage := ""
+	if c.Config != nil {
+		target, _ = c.Config.findTarget(kind, namespace, name)
+		stage = c.Config.Stage
+	}
+
+	team := firstNonEmpty(
+		metadataValue(workloadAnnotations, workloadLabels, teamKey),
+		target.Team,
+		namespaceLabels[teamKey],
+		c.DefaultTeam,
+		defaultLabelValue)
+	service := firstNonEmpty(
+		metadataValue(workloadAnnotations, workloadLabels, serviceKey),
+		target.Service,
+		name)
+	environment := firstNonEmpty(
+		metadataValue(workloadAnnotations, workloadLabels, environmentKey),
+		target.Environment,
+		namespaceLabels[environmentKey],
+		stage,
+		c.DefaultEnvironment,
+		defaultLabelValue)
+
+	return prometheus.Labels{
+		"deployment":  name,
+		"namespace":   namespace,
+		"kind":        kind,
+		"team":        team,
+		"service":     service,
+		"environment": environment,
+	}
+}
@@ -0,0 +1,61 @@
+package dorametrics
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestMetricLabels(t *testing.T) {
+	var tests = []struct {
+		description         string
+		annotations         map[string]string
+		namespaceLabels     map[string]string
+		config              *ControllerConfig
+		defaultTeam         string
+		expectedTeam        string
+		expectedService     string
+		expectedEnvironment string
+	}{
+		{"defaults", nil, nil, nil, "", "unknown", "server-a", "unknown"},
+		{"configured_default", nil, nil, nil, "platform", "platform", "server-a", "unknown"},
+		{"namespace_labels", nil, map[string]string{teamKey: "payments", environmentKey: "staging"}, nil, "platform", "payments", "server-a", "staging"},
+		{"config_stage", nil, nil, &ControllerConfig{Stage: "production"}, "", "unknown", "server-a", "production"},
+		{"config_target",
+			nil,
+			map[string]string{teamKey: "payments"},
+			&ControllerConfig{Stage: "production", Targets: []Target{{Name: "server-a", Namespace: "default", Team: "checkout", Service: "checkout-api", Environment: "prod-eu"}}},
+			"",
+			"checkout", "checkout-api", "prod-eu"},
+		{"workload_annotations",
+			map[string]string{teamKey: "search", serviceKey: "search-api", environmentKey: "prod-us"},
+			map[string]string{teamKey: "payments"},
+			&ControllerConfig{Targets: []Target{{Name: "server-a", Namespace: "default", Team: "checkout"}}},
+			"",
+			"search", "search-api", "prod-us"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			c := newTestController(t)
+			c.Namespaces = cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			c.Namespaces.Add(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", Labels: test.namespaceLabels}})
+			c.Indexers[KindDeployment].Add(deployment("server-a", 1, 1, test.annotations))
+			c.Config = test.config
+			c.DefaultTeam = test.defaultTeam
+
+			labels := c.metricLabels("server-a", "default", KindDeployment)
+			if labels["team"] != test.expectedTeam {
+				t.Errorf("Unexpected team '%s'; expected '%s'", labels["team"], test.expectedTeam)
+			}
+			if labels["service"] != test.expectedService {
+				t.Errorf("Unexpected service '%s'; expected '%s'", labels["service"], test.expectedService)
+			}
+			if labels["environment"] != test.expectedEnvironment {
+				t.Errorf("Unexpected environment '%s'; expected '%s'", labels["environment"], test.expectedEnvironment)
+			}
+		})
+	}
+}
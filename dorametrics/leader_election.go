@@ -0,0 +1,92 @@
+package dorametrics
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	au "github.com/logrusorgru/aurora"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// LeaderElectionConfig holds the Lease settings used to coordinate controller replicas
+type LeaderElectionConfig struct {
+	LeaseName      string
+	LeaseNamespace string
+	Identity       string
+	LeaseDuration  time.Duration
+	RenewDeadline  time.Duration
+	RetryPeriod    time.Duration
+}
+
+// RunLeaderElection competes for the Lease and processes the workqueue while
+// leading; it returns once leadership has been lost or the context is done
+func RunLeaderElection(ctx context.Context, clientset kubernetes.Interface, config LeaderElectionConfig, controller *Controller, threadiness int) error {
+	if len(config.LeaseNamespace) == 0 {
+		return fmt.Errorf("leader election requires a lease namespace")
+	}
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      config.LeaseName,
+			Namespace: config.LeaseNamespace,
+		},
+		Client: clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: config.Identity,
+		},
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   config.LeaseDuration,
+		RenewDeadline:   config.RenewDeadline,
+		RetryPeriod:     config.RetryPeriod,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Println(fmt.Sprintf("%s: %s acquired lease %s/%s", au.Bold(au.Cyan("INFO")), au.Bold(config.Identity), config.LeaseNamespace, config.LeaseName))
+				// the previous leader may have saved state since we started
+				controller.reloadState()
+				controller.Lead(threadiness, ctx.Done())
+			},
+			OnStoppedLeading: func() {
+				log.Println(fmt.Sprintf("%s: %s lost lease %s/%s", au.Bold(au.Cyan("INFO")), au.Bold(config.Identity), config.LeaseNamespace, config.LeaseName))
+			},
+			OnNewLeader: func(identity string) {
+				if identity != config.Identity {
+					log.Println(fmt.Sprintf("%s: following leader %s", au.Bold(au.Cyan("INFO")), au.Bold(identity)))
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	elector.Run(ctx)
+	return nil
+}
+
+// LeaderHandler passes requests that change state on to Handler while the
+// controller leads; followers answer 503, so that senders retry and reach
+// the leader rather than overwrite its state with their own
+type LeaderHandler struct {
+	Controller *Controller
+	Handler    http.Handler
+}
+
+func (h *LeaderHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.Controller.IsLeader() {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, "not the leader", http.StatusServiceUnavailable)
+		return
+	}
+	h.Handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,158 @@
+package dorametrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// waitFor polls condition until it holds or the timeout expires
+func waitFor(timeout time.Duration, condition func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if condition() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return condition()
+}
+
+func TestLeaderElection(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	config := LeaderElectionConfig{
+		LeaseName:      "dora-metrics",
+		LeaseNamespace: "kube-monitoring",
+		LeaseDuration:  time.Second,
+		RenewDeadline:  500 * time.Millisecond,
+		RetryPeriod:    100 * time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	leader := newTestController(t)
+	leaderConfig := config
+	leaderConfig.Identity = "replica-a"
+	go RunLeaderElection(ctx, clientset, leaderConfig, leader, 1)
+	if !waitFor(5*time.Second, leader.IsLeader) {
+		t.Fatalf("Expected replica-a to acquire the lease")
+	}
+
+	follower := newTestController(t)
+	followerConfig := config
+	followerConfig.Identity = "replica-b"
+	go RunLeaderElection(ctx, clientset, followerConfig, follower, 1)
+	go follower.RunFollower(ctx.Done())
+
+	// both replicas see the same workload, but only the leader processes it
+	for _, c := range []*Controller{leader, follower} {
+		c.Indexers[KindDeployment].Add(deployment("server-a", 2, 2, nil))
+		c.Queue.Add("Deployment/default/server-a")
+	}
+	if !waitFor(5*time.Second, func() bool { return leader.Queue.Len() == 0 }) {
+		t.Errorf("Expected the leader to process its workqueue")
+	}
+	// give the follower a few retry periods to (wrongly) start working
+	time.Sleep(3 * config.RetryPeriod)
+	if follower.Queue.Len() != 1 || len(follower.State) != 0 {
+		t.Errorf("Expected the follower to leave its workqueue alone; %d items left, state %+v", follower.Queue.Len(), follower.State)
+	}
+
+	if value := testutil.ToFloat64(leader.Collectors.LeaderGauge); value != 1 {
+		t.Errorf("Unexpected leader gauge %v for the leader; expected 1", value)
+	}
+	if value := testutil.ToFloat64(follower.Collectors.LeaderGauge); value != 0 {
+		t.Errorf("Unexpected leader gauge %v for the follower; expected 0", value)
+	}
+	if follower.IsLeader() {
+		t.Errorf("Expected replica-b to follow")
+	}
+}
+
+func TestLeaderHandler(t *testing.T) {
+	c := newTestController(t)
+	handler := &LeaderHandler{Controller: c, Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})}
+	post := func() int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/api/v1/deployments", nil))
+		return recorder.Code
+	}
+
+	if status := post(); status != http.StatusServiceUnavailable {
+		t.Errorf("Unexpected status %d on a follower; expected %d", status, http.StatusServiceUnavailable)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		c.Lead(1, stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+	if !waitFor(5*time.Second, c.IsLeader) {
+		t.Fatalf("Expected the controller to lead")
+	}
+	if status := post(); status != http.StatusAccepted {
+		t.Errorf("Unexpected status %d on the leader; expected %d", status, http.StatusAccepted)
+	}
+}
+
+func TestLeaderReloadsState(t *testing.T) {
+	store := &FileStateStore{Path: filepath.Join(t.TempDir(), "state.json")}
+	c := newTestController(t)
+	c.Store = store
+	for _, name := range []string{"server-a", "server-b", "server-c"} {
+		c.Indexers[KindDeployment].Add(deployment(name, 2, 2, nil))
+	}
+
+	// what the follower remembers from before the previous leader took over
+	c.State["Deployment/default/server-a"] = DeploymentInfo{Name: "server-a", Namespace: "default", Kind: KindDeployment, Replicas: 2, ErrorStart: 1626600000, ErrorState: availabilityDown}
+	c.State["Deployment/default/server-c"] = DeploymentInfo{Name: "server-c", Namespace: "default", Kind: KindDeployment, Replicas: 2}
+	c.Dedup["Deployment/default/server-c"] = "1626600056"
+
+	// what the previous leader saved: server-a recovered, server-c was forgotten
+	saved := emptyState()
+	saved.State["Deployment/default/server-a"] = DeploymentInfo{Name: "server-a", Namespace: "default", Kind: KindDeployment, Replicas: 2}
+	saved.State["Deployment/default/server-b"] = DeploymentInfo{Name: "server-b", Namespace: "default", Kind: KindDeployment, Replicas: 2}
+	if err := store.Save(saved); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := LeaderElectionConfig{
+		LeaseName:      "dora-metrics",
+		LeaseNamespace: "kube-monitoring",
+		Identity:       "replica-b",
+		LeaseDuration:  time.Second,
+		RenewDeadline:  500 * time.Millisecond,
+		RetryPeriod:    100 * time.Millisecond,
+	}
+	go RunLeaderElection(ctx, fake.NewSimpleClientset(), config, c, 1)
+	if !waitFor(5*time.Second, c.IsLeader) {
+		t.Fatalf("Expected replica-b to acquire the lease")
+	}
+
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+	if _, ok := c.State["Deployment/default/server-c"]; ok || len(c.State) != 2 {
+		t.Errorf("Expected the stale state to be replaced; got %+v", c.State)
+	}
+	if c.State["Deployment/default/server-a"].ErrorStart != 0 {
+		t.Errorf("Expected the outage of server-a to be over; got %+v", c.State["Deployment/default/server-a"])
+	}
+	if len(c.Dedup) != 0 {
+		t.Errorf("Unexpected dedup entries %+v", c.Dedup)
+	}
+}
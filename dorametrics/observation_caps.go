@@ -0,0 +1,81 @@
+package dorametrics
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	au "github.com/logrusorgru/aurora"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// default caps on observations, in seconds
+const defaultMaxCycleTimeSeconds = 7200
+const defaultMaxTimeToRecoverySeconds = 7200
+
+const annotationNameMaxCycleTime = "max-cycle-time"
+const annotationNameMaxTimeToRecovery = "max-time-to-recovery"
+
+// values of the metric label of dora_clamped_observations_total
+const (
+	observationCycleTime      = "cycle_time"
+	observationTimeToRecovery = "time_to_recovery"
+)
+
+// observationCap resolves the cap on cycle time or time to recovery for a
+// workload: annotation, configured target, configuration file, then the
+// command line default; 0 disables the cap
+func (c *Controller) observationCap(observation string, kind string, namespace string, name string) int64 {
+	annotationName, limit := annotationNameMaxCycleTime, c.MaxCycleTimeSeconds
+	if observation == observationTimeToRecovery {
+		annotationName, limit = annotationNameMaxTimeToRecovery, c.MaxTimeToRecoverySeconds
+	}
+	configured := func(cycleTime *int64, timeToRecovery *int64) *int64 {
+		if observation == observationTimeToRecovery {
+			return timeToRecovery
+		}
+		return cycleTime
+	}
+
+	if c.Config != nil {
+		if value := configured(c.Config.MaxCycleTimeSeconds, c.Config.MaxTimeToRecoverySeconds); value != nil {
+			limit = *value
+		}
+		if target, ok := c.Config.findTarget(kind, namespace, name); ok {
+			if value := configured(target.MaxCycleTimeSeconds, target.MaxTimeToRecoverySeconds); value != nil {
+				limit = *value
+			}
+		}
+	}
+
+	if workload := c.lookupWorkload(kind, namespace, name); workload != nil {
+		if value, ok := workload.GetAnnotations()[fmt.Sprintf("%s/%s", annotationPrefix, annotationName)]; ok {
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || seconds < 0 {
+				log.Println(fmt.Sprintf("%s: cannot parse annotation %s/%s=%s on %s %s in namespace %s", au.Bold(au.Red("Error")), annotationPrefix, annotationName, value, kind, au.Bold(name), au.Bold(namespace)))
+			} else {
+				limit = seconds
+			}
+		}
+	}
+	return limit
+}
+
+// clampObservation caps an observation for a workload, counting the values
+// it rewrites; callers hold c.Mutex
+func (c *Controller) clampObservation(observation string, seconds int64, kind string, namespace string, name string, metricLabels prometheus.Labels) int64 {
+	limit := c.observationCap(observation, kind, namespace, name)
+	if limit == 0 || seconds <= limit {
+		return seconds
+	}
+
+	log.Println(fmt.Sprintf("%s: clamping %s of %d seconds to %d for %s %s in namespace %s", au.Bold(au.Cyan("INFO")), observation, au.Bold(seconds), au.Bold(limit), kind, au.Bold(name), au.Bold(namespace)))
+	labels := prometheus.Labels{"metric": observation}
+	for labelName, value := range metricLabels {
+		if labelName != "state" && labelName != "source" {
+			labels[labelName] = value
+		}
+	}
+	c.Collectors.ClampedCounter.With(labels).Inc()
+	return limit
+}
@@ -0,0 +1,52 @@
+package dorametrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func int64Ptr(i int64) *int64 { return &i }
+
+func TestClampObservation(t *testing.T) {
+	var tests = []struct {
+		description string
+		annotations map[string]string
+		config      *ControllerConfig
+		seconds     int64
+		expected    int64
+		clamped     float64
+	}{
+		{"below_default", nil, nil, 3600, 3600, 0},
+		{"above_default", nil, nil, 86400, 7200, 1},
+		{"annotation", map[string]string{"dora-controller/max-cycle-time": "172800"}, nil, 86400, 86400, 0},
+		{"annotation_disables", map[string]string{"dora-controller/max-cycle-time": "0"}, nil, 864000, 864000, 0},
+		{"config", nil, &ControllerConfig{MaxCycleTimeSeconds: int64Ptr(3600)}, 7000, 3600, 1},
+		{"config_target", nil, &ControllerConfig{
+			MaxCycleTimeSeconds: int64Ptr(3600),
+			Targets:             []Target{{Name: "server-a", Namespace: "default", MaxCycleTimeSeconds: int64Ptr(0)}},
+		}, 864000, 864000, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			c := newTestController(t)
+			c.Config = test.config
+			c.Indexers[KindDeployment].Add(deployment("server-a", 1, 1, test.annotations))
+			labels := c.metricLabels("server-a", "default", KindDeployment)
+
+			seconds := c.clampObservation(observationCycleTime, test.seconds, KindDeployment, "default", "server-a", labels)
+			if seconds != test.expected {
+				t.Errorf("Unexpected observation %d; expected %d", seconds, test.expected)
+			}
+			clampedLabels := prometheus.Labels{"metric": observationCycleTime}
+			for name, value := range labels {
+				clampedLabels[name] = value
+			}
+			if value := testutil.ToFloat64(c.Collectors.ClampedCounter.With(clampedLabels)); value != test.clamped {
+				t.Errorf("Unexpected clamped count %v; expected %v", value, test.clamped)
+			}
+		})
+	}
+}
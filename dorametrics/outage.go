@@ -0,0 +1,140 @@
+package dorametrics
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"time"
+
+	au "github.com/logrusorgru/aurora"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gracePeriods resolves how long a workload must stay unavailable before an
+// outage opens, and fully available before it closes: configured target,
+// configuration file, then the command line defaults
+func (c *Controller) gracePeriods(kind string, namespace string, name string) (time.Duration, time.Duration) {
+	openGracePeriod, closeGracePeriod := c.DefaultOpenGracePeriod, c.DefaultCloseGracePeriod
+	if c.Config == nil {
+		return openGracePeriod, closeGracePeriod
+	}
+	if c.Config.OpenGracePeriodSeconds > 0 {
+		openGracePeriod = time.Duration(c.Config.OpenGracePeriodSeconds) * time.Second
+	}
+	if c.Config.CloseGracePeriodSeconds > 0 {
+		closeGracePeriod = time.Duration(c.Config.CloseGracePeriodSeconds) * time.Second
+	}
+	if target, ok := c.Config.findTarget(kind, namespace, name); ok {
+		if target.OpenGracePeriodSeconds > 0 {
+			openGracePeriod = time.Duration(target.OpenGracePeriodSeconds) * time.Second
+		}
+		if target.CloseGracePeriodSeconds > 0 {
+			closeGracePeriod = time.Duration(target.CloseGracePeriodSeconds) * time.Second
+		}
+	}
+	return openGracePeriod, closeGracePeriod
+}
+
+// trackOutage opens and closes outages as the workload's availability state
+// changes. A change only takes effect once it has lasted for the grace
+// period; until then, PendingSince records when it was first seen and the
+// workload is requeued for when the grace period ends. Outages start, and
+// recover, at that first sighting. Callers hold c.Mutex; the return value
+// tells whether the workload's state changed.
+func (c *Controller) trackOutage(key string, workload Workload, state string, metricLabels prometheus.Labels, now int64) bool {
+	kind, namespace, name := workload.Kind(), workload.GetNamespace(), workload.GetName()
+	info := c.State[key]
+	previous := info
+
+	// state saved before degraded states existed only knows about outages
+	if info.ErrorStart > 0 && len(info.ErrorState) == 0 {
+		info.ErrorState = availabilityDown
+	}
+	openGracePeriod, closeGracePeriod := c.gracePeriods(kind, namespace, name)
+
+	// waiting reports whether a pending change still has to wait, and requeues
+	// the workload for when it no longer has to
+	waiting := func(gracePeriod time.Duration) bool {
+		remaining := time.Duration(info.PendingSince-now)*time.Second + gracePeriod
+		if remaining <= 0 {
+			return false
+		}
+		c.Queue.AddAfter(key, remaining)
+		return true
+	}
+
+	switch {
+	case info.ErrorStart == 0 && (state == availabilityDown || state == availabilityDegraded):
+		// a pending outage counts as down if it was down at any point
+		if info.PendingSince == 0 {
+			info.PendingSince = now
+			info.PendingState = state
+		} else if state == availabilityDown {
+			info.PendingState = state
+		}
+		if waiting(openGracePeriod) {
+			break
+		}
+
+		log.Println(fmt.Sprintf("%s: entered %s state for deployment %s in namespace %s", au.Bold(au.Cyan("INFO")), info.PendingState, au.Bold(name), au.Bold(namespace)))
+		info.ErrorStart = info.PendingSince
+		info.ErrorState = info.PendingState
+		info.PendingSince = 0
+		info.PendingState = ""
+		c.Collectors.DowntimeCounter.With(withSource(withState(metricLabels, info.ErrorState), recoverySourcePods)).Inc()
+		c.recordEvent(EventRecord{Type: EventOutageOpened, Time: info.ErrorStart, Kind: kind, Namespace: namespace, Name: name, State: info.ErrorState}, metricLabels)
+		c.alertOnTransition(kind, namespace, name, info.ErrorState)
+
+		// an outage soon after a successful deployment is blamed on it
+		if window := c.changeFailureWindow(kind, namespace, name); window > 0 && info.ErrorStart-info.LastDeployedAt <= window {
+			c.markChangeFailure(&info, recoverySourcePods, "", info.ErrorStart)
+		}
+
+	case info.ErrorStart > 0 && state == availabilityAvailable:
+		if info.PendingSince == 0 {
+			info.PendingSince = now
+		}
+		if waiting(closeGracePeriod) {
+			break
+		}
+
+		timeToRecovery := info.PendingSince - info.ErrorStart
+		c.recordEvent(EventRecord{
+			Type:            EventOutageClosed,
+			Time:            info.PendingSince,
+			Kind:            kind,
+			Namespace:       namespace,
+			Name:            name,
+			State:           info.ErrorState,
+			Start:           info.ErrorStart,
+			DurationSeconds: timeToRecovery,
+		}, metricLabels)
+		timeToRecovery = c.clampObservation(observationTimeToRecovery, timeToRecovery, kind, namespace, name, metricLabels)
+		log.Println(fmt.Sprintf("%s: left %s state for deployment %s in namespace %s: TTR was %d", au.Bold(au.Cyan("INFO")), info.ErrorState, au.Bold(name), au.Bold(namespace), au.Bold(timeToRecovery)))
+		recoveryLabels := withSource(withState(metricLabels, info.ErrorState), recoverySourcePods)
+		c.Collectors.TimeToRecoveryGauge.With(recoveryLabels).Set(math.Round(float64(timeToRecovery)))
+		c.Collectors.TimeToRecoveryHistogram.With(recoveryLabels).Observe(float64(timeToRecovery))
+		info.ErrorStart = 0
+		info.ErrorState = ""
+		info.PendingSince = 0
+		c.alertOnTransition(kind, namespace, name, "")
+
+	default:
+		// whatever was pending didn't last; partially available deployments
+		// keep their state until all replicas are ready
+		info.PendingSince = 0
+		info.PendingState = ""
+		if info.ErrorStart > 0 && state == availabilityDown && info.ErrorState == availabilityDegraded {
+			// the outage keeps its start and its downtime count, but
+			// recovers from down
+			log.Println(fmt.Sprintf("%s: degraded deployment %s in namespace %s is down", au.Bold(au.Cyan("INFO")), au.Bold(name), au.Bold(namespace)))
+			info.ErrorState = state
+		}
+	}
+
+	if info == previous {
+		return false
+	}
+	c.State[key] = info
+	return true
+}
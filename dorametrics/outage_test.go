@@ -0,0 +1,138 @@
+package dorametrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestOutageGracePeriods(t *testing.T) {
+	c := newTestController(t)
+	c.DefaultOpenGracePeriod = time.Minute
+	c.DefaultCloseGracePeriod = 2 * time.Minute
+	labels := withState(c.metricLabels("server-a", "default", KindDeployment), availabilityDown)
+	key := "Deployment/default/server-a"
+	sync := func(readyReplicas int32) {
+		c.Indexers[KindDeployment].Add(deployment("server-a", 2, readyReplicas, nil))
+		if err := c.syncToStdout(key); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	// rewind moves the start of whatever is pending into the past
+	rewind := func(seconds int64) int64 {
+		info := c.State[key]
+		info.PendingSince -= seconds
+		c.State[key] = info
+		return info.PendingSince
+	}
+
+	// a flapping pod doesn't open an outage
+	sync(0)
+	sync(2)
+	if c.State[key].PendingSince != 0 || c.State[key].ErrorStart != 0 {
+		t.Errorf("Unexpected state %+v after flapping", c.State[key])
+	}
+
+	// an outage opens once the grace period has passed, starting when it was first seen
+	sync(0)
+	start := rewind(61)
+	sync(0)
+	if c.State[key].ErrorStart != start {
+		t.Errorf("Unexpected outage start %d; expected %d", c.State[key].ErrorStart, start)
+	}
+	if value := testutil.ToFloat64(c.Collectors.DowntimeCounter.With(withSource(labels, recoverySourcePods))); value != 1 {
+		t.Errorf("Unexpected downtime count %v; expected 1", value)
+	}
+
+	// a brief recovery doesn't close it
+	sync(2)
+	sync(0)
+	if c.State[key].ErrorStart != start || c.State[key].PendingSince != 0 {
+		t.Errorf("Unexpected state %+v after brief recovery", c.State[key])
+	}
+
+	// it closes once healthy for the grace period, recovering when first healthy
+	sync(2)
+	end := rewind(121)
+	sync(2)
+	if c.State[key].ErrorStart != 0 {
+		t.Fatalf("Expected outage to be closed")
+	}
+	if value := testutil.ToFloat64(c.Collectors.TimeToRecoveryGauge.With(withSource(labels, recoverySourcePods))); value != float64(end-start) {
+		t.Errorf("Unexpected time to recovery %v; expected %d", value, end-start)
+	}
+}
+
+func TestOutageRequeue(t *testing.T) {
+	c := newTestController(t)
+	c.DefaultOpenGracePeriod = time.Second
+	key := "Deployment/default/server-a"
+	c.Indexers[KindDeployment].Add(deployment("server-a", 2, 0, nil))
+	if err := c.syncToStdout(key); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// the workload comes back once the grace period is over
+	requeued := make(chan interface{})
+	go func() {
+		item, _ := c.Queue.Get()
+		requeued <- item
+	}()
+	select {
+	case item := <-requeued:
+		if item != key {
+			t.Errorf("Unexpected item %v; expected %s", item, key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected %s to be requeued", key)
+	}
+}
+
+func TestOutageChangeFailure(t *testing.T) {
+	var tests = []struct {
+		description  string
+		window       time.Duration
+		configured   *int64
+		deployedAgo  int64
+		changeFailed bool
+	}{
+		{"within_window", time.Hour, nil, 600, true},
+		{"outside_window", time.Hour, nil, 7200, false},
+		{"disabled", 0, nil, 600, false},
+		{"configured_window", time.Hour, int64Ptr(10800), 7200, true},
+		{"configured_disabled", time.Hour, int64Ptr(0), 600, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			c := newTestController(t)
+			c.DefaultChangeFailureWindow = test.window
+			c.Config = &ControllerConfig{ChangeFailureWindowSeconds: test.configured}
+			key := "Deployment/default/server-a"
+			labels := withSource(c.metricLabels("server-a", "default", KindDeployment), recoverySourcePods)
+			sync := func(readyReplicas int32) {
+				c.Indexers[KindDeployment].Add(deployment("server-a", 2, readyReplicas, nil))
+				if err := c.syncToStdout(key); err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+			}
+
+			sync(2)
+			info := c.State[key]
+			info.LastDeployedAt = time.Now().Unix() - test.deployedAgo
+			c.State[key] = info
+
+			// a second outage after the same deployment isn't counted again
+			sync(0)
+			sync(2)
+			sync(0)
+			if changeFailed := testutil.ToFloat64(c.Collectors.ChangeFailureCounter.With(labels)) == 1; changeFailed != test.changeFailed {
+				t.Errorf("Unexpected change failure %t; expected %t", changeFailed, test.changeFailed)
+			}
+			if changeFailed := c.State[key].ChangeFailureAt == info.LastDeployedAt; changeFailed != test.changeFailed {
+				t.Errorf("Unexpected change failure time %d", c.State[key].ChangeFailureAt)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package dorametrics
 
 import (
+	"crypto/sha256"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -8,12 +9,17 @@ import (
 	"github.com/ghodss/yaml"
 )
 
-func parseConfig(configPath string, config *ControllerConfig, lookup *map[string]bool) error {
+const configModeAugment = "augment"
+const configModeRestrict = "restrict"
+
+func parseConfig(configPath string, config *ControllerConfig) error {
 	byteArray, err := ioutil.ReadFile(configPath)
 	if err != nil {
 		return fmt.Errorf("can't read configuration file %s: %v", configPath, err)
 	}
 
+	config.checksum = sha256.Sum256(byteArray)
+
 	jsonArray, err := yaml.YAMLToJSON(byteArray)
 	if err != nil {
 		return fmt.Errorf("can't convert configuration file %s to JSON: %v", configPath, err)
@@ -24,11 +30,95 @@ func parseConfig(configPath string, config *ControllerConfig, lookup *map[string
 		return fmt.Errorf("can't unmarshal configuration file %s to internal data structure: %v", configPath, err)
 	}
 
-	// populate lookup
+	switch config.Mode {
+	case "":
+		config.Mode = configModeAugment
+	case configModeAugment, configModeRestrict:
+	default:
+		return fmt.Errorf("unknown mode %s in configuration file %s", config.Mode, configPath)
+	}
+
+	thresholds := []float64{config.AvailabilityThreshold}
+	for _, target := range config.Targets {
+		thresholds = append(thresholds, target.AvailabilityThreshold)
+	}
+	for _, threshold := range thresholds {
+		if threshold < 0 || threshold > 1 {
+			return fmt.Errorf("availability threshold %v in configuration file %s must be between 0 and 1", threshold, configPath)
+		}
+	}
+
+	gracePeriods := []int64{config.OpenGracePeriodSeconds, config.CloseGracePeriodSeconds}
 	for _, target := range config.Targets {
-		key := target.Namespace + target.Name
-		(*lookup)[key] = true
+		gracePeriods = append(gracePeriods, target.OpenGracePeriodSeconds, target.CloseGracePeriodSeconds)
+	}
+	for _, gracePeriod := range gracePeriods {
+		if gracePeriod < 0 {
+			return fmt.Errorf("grace period %d in configuration file %s must not be negative", gracePeriod, configPath)
+		}
+	}
+
+	caps := []*int64{config.MaxCycleTimeSeconds, config.MaxTimeToRecoverySeconds}
+	for _, target := range config.Targets {
+		caps = append(caps, target.MaxCycleTimeSeconds, target.MaxTimeToRecoverySeconds)
+	}
+	for _, limit := range caps {
+		if limit != nil && *limit < 0 {
+			return fmt.Errorf("maximum %d in configuration file %s must not be negative", *limit, configPath)
+		}
+	}
+
+	windows := []*int64{config.ChangeFailureWindowSeconds}
+	for _, target := range config.Targets {
+		windows = append(windows, target.ChangeFailureWindowSeconds)
+	}
+	for _, window := range windows {
+		if window != nil && *window < 0 {
+			return fmt.Errorf("change failure window %d in configuration file %s must not be negative", *window, configPath)
+		}
 	}
 
 	return nil
 }
+
+// LoadConfig reads the YAML configuration file at configPath
+func LoadConfig(configPath string) (*ControllerConfig, error) {
+	config := ControllerConfig{}
+	err := parseConfig(configPath, &config)
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// ExtendsTracking tells whether targets add workloads that needn't carry the
+// enabled label, so that the informers can't filter by it
+func (config *ControllerConfig) ExtendsTracking() bool {
+	return config != nil && config.Mode == configModeAugment && len(config.Targets) > 0
+}
+
+// findTarget returns the configured target for a workload; targets without
+// a kind match workloads of any kind
+func (config *ControllerConfig) findTarget(kind string, namespace string, name string) (Target, bool) {
+	for _, target := range config.Targets {
+		if target.Name == name && target.Namespace == namespace && (len(target.Kind) == 0 || target.Kind == kind) {
+			return target, true
+		}
+	}
+	return Target{}, false
+}
+
+// tracked decides whether a workload counts for DORA purposes: without a
+// configuration file the informers only deliver labelled workloads; with one,
+// targets are added to (augment) or intersected with (restrict) them
+func (c *Controller) tracked(kind string, namespace string, name string, labels map[string]string) bool {
+	labelled := labels[EnabledLabel] == "true"
+	if c.Config == nil {
+		return labelled
+	}
+	_, listed := c.Config.findTarget(kind, namespace, name)
+	if c.Config.Mode == configModeRestrict {
+		return labelled && listed
+	}
+	return labelled || listed
+}
@@ -0,0 +1,186 @@
+package dorametrics
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+const testConfig = `stage: production
+mode: %s
+deployments:
+  - name: server-a
+    namespace: default
+    team: payments
+    alert: true
+`
+
+func fmtConfig(mode string) string {
+	return fmt.Sprintf(testConfig, mode)
+}
+
+func writeConfig(t *testing.T, path string, content string) {
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		t.Fatalf("Can't write configuration file: %v", err)
+	}
+}
+
+func TestTracked(t *testing.T) {
+	labelled := map[string]string{EnabledLabel: "true"}
+
+	var tests = []struct {
+		description string
+		mode        string
+		name        string
+		labels      map[string]string
+		expected    bool
+	}{
+		{"no_config_labelled", "", "server-b", labelled, true},
+		{"no_config_unlabelled", "", "server-b", nil, false},
+		{"augment_listed", "augment", "server-a", nil, true},
+		{"augment_labelled", "augment", "server-b", labelled, true},
+		{"augment_neither", "augment", "server-b", nil, false},
+		{"restrict_listed_and_labelled", "restrict", "server-a", labelled, true},
+		{"restrict_listed_only", "restrict", "server-a", nil, false},
+		{"restrict_labelled_only", "restrict", "server-b", labelled, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			c := newTestController(t)
+			if len(test.mode) > 0 {
+				path := filepath.Join(t.TempDir(), "config.yaml")
+				writeConfig(t, path, fmtConfig(test.mode))
+				config, err := LoadConfig(path)
+				if err != nil {
+					t.Fatalf("Unexpected error loading configuration: %v", err)
+				}
+				c.Config = config
+			}
+			if actual := c.tracked(KindDeployment, "default", test.name, test.labels); actual != test.expected {
+				t.Errorf("Unexpected tracked value %t; expected %t", actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestLoadConfigInvalidMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, fmtConfig("sometimes"))
+	if _, err := LoadConfig(path); err == nil {
+		t.Errorf("Expected an error for an unknown mode")
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, fmtConfig("augment"))
+
+	c := newTestController(t)
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("Unexpected error loading configuration: %v", err)
+	}
+	c.Config = config
+	c.Indexers[KindDeployment].Add(deployment("server-a", 1, 1, nil))
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.WatchConfig(path, 10*time.Millisecond, stop)
+
+	writeConfig(t, path, fmtConfig("restrict"))
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.Mutex.Lock()
+		mode := c.Config.Mode
+		c.Mutex.Unlock()
+		if mode == configModeRestrict {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c.Mutex.Lock()
+	defer c.Mutex.Unlock()
+	if c.Config.Mode != configModeRestrict {
+		t.Fatalf("Configuration was not reloaded")
+	}
+	if c.Queue.Len() != 1 {
+		t.Errorf("Expected known workloads to be requeued; queue length is %d", c.Queue.Len())
+	}
+}
+
+func TestExtendsTracking(t *testing.T) {
+	var tests = []struct {
+		description string
+		config      *ControllerConfig
+		expected    bool
+	}{
+		{"no_config", nil, false},
+		{"augment_targets", &ControllerConfig{Mode: configModeAugment, Targets: []Target{{Name: "server-a"}}}, true},
+		{"augment_no_targets", &ControllerConfig{Mode: configModeAugment}, false},
+		{"restrict_targets", &ControllerConfig{Mode: configModeRestrict, Targets: []Target{{Name: "server-a"}}}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if actual := test.config.ExtendsTracking(); actual != test.expected {
+				t.Errorf("Unexpected ExtendsTracking %t; expected %t", actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestRemovedTarget(t *testing.T) {
+	server, received := pagerDutyServer(0)
+	defer server.Close()
+	c := newTestController(t)
+	c.Alerter = NewAlerter(server.URL, "routing-key")
+	stop := make(chan struct{})
+	defer close(stop)
+	go c.Alerter.Run(stop)
+	c.Config = &ControllerConfig{Mode: configModeAugment, Targets: []Target{{Name: "server-a", Namespace: "default", Alert: true}}}
+	key := "Deployment/default/server-a"
+
+	// an unlabelled target is tracked until a reload removes it
+	d := deployment("server-a", 2, 0, nil)
+	d.Labels = nil
+	c.Indexers[KindDeployment].Add(d)
+	if err := c.syncToStdout(key); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, ok := c.State[key]; !ok {
+		t.Fatalf("Expected state for the target")
+	}
+	if count := testutil.CollectAndCount(&c.Collectors.DowntimeCounter); count != 1 {
+		t.Fatalf("Unexpected number of downtime series %d; expected 1", count)
+	}
+
+	c.Config = &ControllerConfig{Mode: configModeAugment, Targets: []Target{{Name: "server-b", Namespace: "default"}}}
+	if err := c.syncToStdout(key); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, stateKept := c.State[key]
+	_, dedupKept := c.Dedup[key]
+	if stateKept || dedupKept {
+		t.Errorf("Unexpected state kept=%t, dedup kept=%t; expected both removed", stateKept, dedupKept)
+	}
+	if count := testutil.CollectAndCount(&c.Collectors.DowntimeCounter); count != 0 {
+		t.Errorf("Unexpected number of downtime series %d; expected 0", count)
+	}
+
+	// the page opened for the outage is resolved, though the target is gone
+	if !waitFor(5*time.Second, func() bool { return len(received()) >= 2 }) {
+		t.Fatalf("Unexpected number of PagerDuty events %d; expected 2", len(received()))
+	}
+	for i, action := range []string{"trigger", "resolve"} {
+		if event := received()[i]; event.EventAction != action || event.DedupKey != "dora-metrics/Deployment/default/server-a" {
+			t.Errorf("Unexpected event %s for %s; expected %s", event.EventAction, event.DedupKey, action)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package dorametrics
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	au "github.com/logrusorgru/aurora"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// performance metrics, as in the metric label of dora_performance_tier
+const (
+	performanceDeploymentFrequency = "deployment_frequency"
+	performanceLeadTime            = "lead_time"
+	performanceChangeFailureRate   = "change_failure_rate"
+	performanceTimeToRecovery      = "time_to_recovery"
+)
+
+// Accelerate performance tiers, valued 4 to 1 in dora_performance_tier
+const (
+	tierElite  = "elite"
+	tierHigh   = "high"
+	tierMedium = "medium"
+	tierLow    = "low"
+)
+
+var tierValues = map[string]float64{tierElite: 4, tierHigh: 3, tierMedium: 2, tierLow: 1}
+
+const day = 24 * 60 * 60
+
+// tierBands hold the elite, high and medium bounds of each metric, after the
+// Accelerate State of DevOps report with the gaps between its bands closed;
+// deployment frequency is a minimum in deployments per day, the others are
+// exclusive maxima
+var tierBands = map[string][3]float64{
+	performanceDeploymentFrequency: {1, 1.0 / 7, 1.0 / 30},
+	performanceLeadTime:            {day, 7 * day, 30 * day},
+	performanceChangeFailureRate:   {0.16, 0.31, 0.46},
+	performanceTimeToRecovery:      {60 * 60, day, 7 * day},
+}
+
+// classifyTier returns the tier of a metric value
+func classifyTier(metric string, value float64) string {
+	bands := tierBands[metric]
+	tiers := []string{tierElite, tierHigh, tierMedium}
+	for i, bound := range bands {
+		if metric == performanceDeploymentFrequency && value >= bound ||
+			metric != performanceDeploymentFrequency && value < bound {
+			return tiers[i]
+		}
+	}
+	return tierLow
+}
+
+// MetricPerformance is the value of a DORA metric and its tier
+type MetricPerformance struct {
+	Value float64 `json:"value"`
+	Tier  string  `json:"tier"`
+}
+
+// ServicePerformance holds the DORA metrics of a team's service over a
+// window; metrics without data in the window are left out
+type ServicePerformance struct {
+	Team    string                       `json:"team"`
+	Service string                       `json:"service"`
+	Metrics map[string]MetricPerformance `json:"metrics"`
+}
+
+// Performance computes deployment frequency (successful deployments per
+// day), median lead time, change failure rate and mean time to recovery for
+// every service with events in the window up to now
+func (s *EventStore) Performance(window time.Duration, now int64) ([]ServicePerformance, error) {
+	type sample struct {
+		successes, failures int
+		changeFailures      int
+		leadTimes           []int64
+		recoveries          []int64
+	}
+	samples := map[[2]string]*sample{}
+	from := now - int64(window.Seconds())
+	err := s.scan(from, now, "", func(record EventRecord) {
+		key := [2]string{record.Labels["team"], record.Labels["service"]}
+		current, ok := samples[key]
+		if !ok {
+			current = &sample{}
+			samples[key] = current
+		}
+		switch record.Type {
+		case EventDeploymentSucceeded:
+			current.successes++
+			for _, commitTimestamp := range record.CommitTimestamps {
+				if leadTime := record.Time - commitTimestamp; commitTimestamp > 0 && leadTime >= 0 {
+					current.leadTimes = append(current.leadTimes, leadTime)
+				}
+			}
+		case EventDeploymentFailed:
+			current.failures++
+		case EventChangeFailure:
+			current.changeFailures++
+		case EventOutageClosed:
+			current.recoveries = append(current.recoveries, record.DurationSeconds)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	days := window.Hours() / 24
+	performance := []ServicePerformance{}
+	for key, current := range samples {
+		values := map[string]float64{
+			performanceDeploymentFrequency: float64(current.successes) / days,
+		}
+		if deployments := current.successes + current.failures; deployments > 0 {
+			// deployments that caused an incident count as failures too
+			failed := current.failures + current.changeFailures
+			values[performanceChangeFailureRate] = math.Min(float64(failed)/float64(deployments), 1)
+		}
+		if len(current.leadTimes) > 0 {
+			values[performanceLeadTime] = median(current.leadTimes)
+		}
+		if len(current.recoveries) > 0 {
+			var total int64
+			for _, recovery := range current.recoveries {
+				total += recovery
+			}
+			values[performanceTimeToRecovery] = float64(total) / float64(len(current.recoveries))
+		}
+
+		metrics := map[string]MetricPerformance{}
+		for metric, value := range values {
+			metrics[metric] = MetricPerformance{Value: value, Tier: classifyTier(metric, value)}
+		}
+		performance = append(performance, ServicePerformance{Team: key[0], Service: key[1], Metrics: metrics})
+	}
+	sort.Slice(performance, func(i, j int) bool {
+		if performance[i].Team != performance[j].Team {
+			return performance[i].Team < performance[j].Team
+		}
+		return performance[i].Service < performance[j].Service
+	})
+	return performance, nil
+}
+
+func median(values []int64) float64 {
+	sorted := append([]int64{}, values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return float64(sorted[middle-1]+sorted[middle]) / 2
+	}
+	return float64(sorted[middle])
+}
+
+// updatePerformanceTiers replaces the dora_performance_tier series
+func (c *Controller) updatePerformanceTiers(now int64) {
+	performance, err := c.EventStore.Performance(c.PerformanceWindow, now)
+	if err != nil {
+		log.Println(fmt.Sprintf("%s: can't compute performance tiers: %v", au.Bold(au.Red("Error")), err))
+		return
+	}
+	c.Collectors.PerformanceTierGauge.Reset()
+	for _, service := range performance {
+		for metric, value := range service.Metrics {
+			c.Collectors.PerformanceTierGauge.With(prometheus.Labels{"metric": metric, "team": service.Team, "service": service.Service}).Set(tierValues[value.Tier])
+		}
+	}
+}
+
+// WatchPerformance recomputes the performance tiers from the event store every interval
+func (c *Controller) WatchPerformance(interval time.Duration, stopCh <-chan struct{}) {
+	if c.EventStore == nil {
+		log.Println(fmt.Sprintf("%s: performance tiers require an event store", au.Bold(au.Red("Error"))))
+		return
+	}
+	wait.Until(func() {
+		c.updatePerformanceTiers(time.Now().Unix())
+	}, interval, stopCh)
+}
+
+// PerformanceSummary is the response of the performance endpoint
+type PerformanceSummary struct {
+	From     int64                `json:"from"`
+	To       int64                `json:"to"`
+	Services []ServicePerformance `json:"services"`
+}
+
+// PerformanceHandler summarises the DORA performance of each service as
+// JSON, optionally for a single team or service
+type PerformanceHandler struct {
+	Store  *EventStore
+	Window time.Duration
+}
+
+func (h *PerformanceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().Unix()
+	team := r.URL.Query().Get("team")
+	service := r.URL.Query().Get("service")
+
+	services, err := h.Store.Performance(h.Window, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	summary := PerformanceSummary{From: now - int64(h.Window.Seconds()), To: now, Services: []ServicePerformance{}}
+	for _, performance := range services {
+		if (len(team) == 0 || performance.Team == team) && (len(service) == 0 || performance.Service == service) {
+			summary.Services = append(summary.Services, performance)
+		}
+	}
+	writeJSON(w, summary)
+}
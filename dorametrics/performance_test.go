@@ -0,0 +1,119 @@
+package dorametrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestClassifyTier(t *testing.T) {
+	var tests = []struct {
+		metric   string
+		value    float64
+		expected string
+	}{
+		{performanceDeploymentFrequency, 3, tierElite},
+		{performanceDeploymentFrequency, 1, tierElite},
+		{performanceDeploymentFrequency, 0.5, tierHigh},
+		{performanceDeploymentFrequency, 0.1, tierMedium},
+		{performanceDeploymentFrequency, 0, tierLow},
+		{performanceLeadTime, 3600, tierElite},
+		{performanceLeadTime, 2 * day, tierHigh},
+		{performanceLeadTime, 14 * day, tierMedium},
+		{performanceLeadTime, 90 * day, tierLow},
+		{performanceChangeFailureRate, 0, tierElite},
+		{performanceChangeFailureRate, 0.25, tierHigh},
+		{performanceChangeFailureRate, 0.4, tierMedium},
+		{performanceChangeFailureRate, 0.5, tierLow},
+		{performanceTimeToRecovery, 600, tierElite},
+		{performanceTimeToRecovery, 7200, tierHigh},
+		{performanceTimeToRecovery, 2 * day, tierMedium},
+		{performanceTimeToRecovery, 8 * day, tierLow},
+	}
+
+	for _, test := range tests {
+		if tier := classifyTier(test.metric, test.value); tier != test.expected {
+			t.Errorf("Unexpected tier %s of %s %v; expected %s", tier, test.metric, test.value, test.expected)
+		}
+	}
+}
+
+func TestPerformance(t *testing.T) {
+	now := int64(1626600000)
+	store := openEventStore(t, filepath.Join(t.TempDir(), "events.db"), 0)
+	payments := map[string]string{"team": "payments", "service": "payments-api"}
+	search := map[string]string{"team": "search", "service": "search-api"}
+	records := []EventRecord{
+		// outside the window
+		{Type: EventDeploymentFailed, Time: now - 30*day, Labels: payments},
+		{Type: EventDeploymentSucceeded, Time: now - 6*day, Labels: payments, CommitTimestamps: []int64{now - 6*day - 600, now - 6*day - 1800}},
+		{Type: EventDeploymentSucceeded, Time: now - 4*day, Labels: payments, CommitTimestamps: []int64{now - 4*day - 3600}},
+		{Type: EventDeploymentFailed, Time: now - 2*day, Labels: payments},
+		// an incident blamed on the deployment 4 days ago
+		{Type: EventChangeFailure, Time: now - 2*day + 3600, Labels: payments, DeployedAt: now - 4*day},
+		{Type: EventOutageClosed, Time: now - 2*day + 7200, Labels: payments, DurationSeconds: 1800},
+		{Type: EventOutageClosed, Time: now - day, Labels: payments, DurationSeconds: 5400},
+		// seen before the window only
+		{Type: EventDeploymentSucceeded, Time: now - 20*day, Labels: search},
+	}
+	for _, record := range records {
+		if err := store.Record(record); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	performance, err := store.Performance(7*24*time.Hour, now)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(performance) != 1 {
+		t.Fatalf("Unexpected performance %+v; expected the service with events in the window only", performance)
+	}
+	expected := map[string]MetricPerformance{
+		performanceDeploymentFrequency: {2.0 / 7, tierHigh},
+		performanceLeadTime:            {1800, tierElite},
+		performanceChangeFailureRate:   {2.0 / 3, tierLow},
+		performanceTimeToRecovery:      {3600, tierHigh},
+	}
+	if performance[0].Service != "payments-api" || len(performance[0].Metrics) != len(expected) {
+		t.Fatalf("Unexpected performance %+v", performance[0])
+	}
+	for metric, value := range expected {
+		if performance[0].Metrics[metric] != value {
+			t.Errorf("Unexpected %s %+v; expected %+v", metric, performance[0].Metrics[metric], value)
+		}
+	}
+
+	c := newTestController(t)
+	c.EventStore = store
+	c.PerformanceWindow = 7 * 24 * time.Hour
+	c.updatePerformanceTiers(now)
+	labels := prometheus.Labels{"metric": performanceChangeFailureRate, "team": "payments", "service": "payments-api"}
+	if value := testutil.ToFloat64(c.Collectors.PerformanceTierGauge.With(labels)); value != 1 {
+		t.Errorf("Unexpected tier %v; expected 1", value)
+	}
+	if count := testutil.CollectAndCount(&c.Collectors.PerformanceTierGauge); count != 4 {
+		t.Errorf("Unexpected number of tier series %d; expected 4", count)
+	}
+
+	// the handler rates the window up to the present
+	if err := store.Record(EventRecord{Type: EventDeploymentSucceeded, Time: time.Now().Unix() - day, Labels: search}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	handler := &PerformanceHandler{Store: store, Window: 7 * 24 * time.Hour}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/performance?team=search", nil))
+	var summary PerformanceSummary
+	if err := json.Unmarshal(recorder.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("Can't parse response %s: %v", recorder.Body.String(), err)
+	}
+	if len(summary.Services) != 1 || summary.Services[0].Service != "search-api" || summary.To-summary.From != 7*day {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+}
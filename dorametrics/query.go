@@ -0,0 +1,122 @@
+package dorametrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MethodHandler routes requests to a handler by HTTP method
+type MethodHandler map[string]http.Handler
+
+func (m MethodHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler, ok := m[r.Method]
+	if !ok {
+		var allowed []string
+		for method := range m {
+			allowed = append(allowed, method)
+		}
+		sort.Strings(allowed)
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// DeploymentsQueryHandler lists recorded deployments as JSON
+type DeploymentsQueryHandler struct {
+	Store *EventStore
+}
+
+func (h *DeploymentsQueryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r.URL.Query(), "success", "failure")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	deployments, err := h.Store.Deployments(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, deployments)
+}
+
+// IncidentsQueryHandler lists recorded outages as JSON
+type IncidentsQueryHandler struct {
+	Store *EventStore
+}
+
+func (h *IncidentsQueryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	filter, err := parseEventFilter(r.URL.Query(), "resolved", "open")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	incidents, err := h.Store.Incidents(filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, incidents)
+}
+
+// parseEventFilter reads the namespace, team, service, from, to and outcome
+// query parameters; times are unix seconds or RFC 3339
+func parseEventFilter(query url.Values, outcomes ...string) (EventFilter, error) {
+	filter := EventFilter{
+		Namespace: query.Get("namespace"),
+		Team:      query.Get("team"),
+		Service:   query.Get("service"),
+		Outcome:   query.Get("outcome"),
+	}
+
+	var err error
+	if filter.From, err = ParseTime(query.Get("from")); err != nil {
+		return filter, fmt.Errorf("invalid from: %v", err)
+	}
+	if filter.To, err = ParseTime(query.Get("to")); err != nil {
+		return filter, fmt.Errorf("invalid to: %v", err)
+	}
+
+	if len(filter.Outcome) > 0 {
+		for _, outcome := range outcomes {
+			if filter.Outcome == outcome {
+				return filter, nil
+			}
+		}
+		return filter, fmt.Errorf("invalid outcome %s; expected one of %s", filter.Outcome, strings.Join(outcomes, ", "))
+	}
+	return filter, nil
+}
+
+// ParseTime reads unix seconds or an RFC 3339 time; empty means 0
+func ParseTime(value string) (int64, error) {
+	if len(value) == 0 {
+		return 0, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return seconds, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return 0, fmt.Errorf("%s is neither unix seconds nor RFC 3339", value)
+	}
+	return parsed.Unix(), nil
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	body, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("can't encode response: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
@@ -1,18 +1,80 @@
 package dorametrics
 
 import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
-func RegisterCollectors(collectors *Collectors, dryrun bool) error {
+// DefaultCycleTimeBuckets spans one minute to two hours
+var DefaultCycleTimeBuckets = []float64{60, 120, 300, 600, 900, 1200, 1800, 2700, 3600, 5400, 7200}
+
+// DefaultTimeToRecoveryBuckets spans thirty seconds to two hours
+var DefaultTimeToRecoveryBuckets = []float64{30, 60, 120, 300, 600, 900, 1800, 2700, 3600, 5400, 7200}
+
+// workloadLabelNames identify the workload behind every DORA metric;
+// "deployment" holds the workload name whatever its kind
+var workloadLabelNames = []string{"deployment", "namespace", "kind", "team", "service", "environment"}
+
+// outageLabelNames add the availability state, down or degraded, to outage metrics
+var outageLabelNames = append([]string{"state"}, workloadLabelNames...)
+
+// recoveryLabelNames add where the outage was detected to downtime and time to
+// recovery metrics, so that outages seen by several sources can be told apart
+var recoveryLabelNames = append([]string{"source"}, outageLabelNames...)
+
+// DefaultLeadTimeBuckets spans five minutes to thirty days
+var DefaultLeadTimeBuckets = []float64{300, 900, 1800, 3600, 10800, 21600, 43200, 86400, 172800, 259200, 604800, 1209600, 2592000}
+
+// CollectorOptions configures the histogram collectors
+type CollectorOptions struct {
+	CycleTimeBuckets      []float64
+	TimeToRecoveryBuckets []float64
+	LeadTimeBuckets       []float64
+}
+
+// ParseBuckets turns a comma-separated list of upper bounds into histogram buckets
+func ParseBuckets(list string) ([]float64, error) {
+	var buckets []float64
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		bucket, err := strconv.ParseFloat(item, 64)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse bucket %s: %v", item, err)
+		}
+		buckets = append(buckets, bucket)
+	}
+	if len(buckets) == 0 {
+		return nil, fmt.Errorf("no buckets in %q", list)
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		return nil, fmt.Errorf("buckets %q must be in increasing order", list)
+	}
+	return buckets, nil
+}
+
+func RegisterCollectors(collectors *Collectors, options CollectorOptions, dryrun bool) error {
+	if len(options.CycleTimeBuckets) == 0 {
+		options.CycleTimeBuckets = DefaultCycleTimeBuckets
+	}
+	if len(options.TimeToRecoveryBuckets) == 0 {
+		options.TimeToRecoveryBuckets = DefaultTimeToRecoveryBuckets
+	}
+	if len(options.LeadTimeBuckets) == 0 {
+		options.LeadTimeBuckets = DefaultLeadTimeBuckets
+	}
+
 	collectors.SuccessCounter = *prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "dora_successful_deployments_total",
 		Help: "counter for successful deployments",
 	},
-		[]string{
-			"deployment",
-			"namespace",
-		})
+		workloadLabelNames)
 	if !dryrun {
 		prometheus.MustRegister(collectors.SuccessCounter)
 	}
@@ -21,10 +83,7 @@ func RegisterCollectors(collectors *Collectors, dryrun bool) error {
 		Name: "dora_failed_deployments_total",
 		Help: "counter for failed deployments",
 	},
-		[]string{
-			"deployment",
-			"namespace",
-		})
+		workloadLabelNames)
 
 	if !dryrun {
 		prometheus.MustRegister(collectors.FailureCounter)
@@ -34,10 +93,7 @@ func RegisterCollectors(collectors *Collectors, dryrun bool) error {
 		Name: "dora_downtime_total",
 		Help: "counter for periods of downtime",
 	},
-		[]string{
-			"deployment",
-			"namespace",
-		})
+		recoveryLabelNames)
 
 	if !dryrun {
 		prometheus.MustRegister(collectors.DowntimeCounter)
@@ -47,10 +103,7 @@ func RegisterCollectors(collectors *Collectors, dryrun bool) error {
 		Name: "dora_time_to_recovery_seconds",
 		Help: "gauge for time to recovery",
 	},
-		[]string{
-			"deployment",
-			"namespace",
-		})
+		recoveryLabelNames)
 
 	if !dryrun {
 		prometheus.MustRegister(collectors.TimeToRecoveryGauge)
@@ -60,14 +113,104 @@ func RegisterCollectors(collectors *Collectors, dryrun bool) error {
 		Name: "dora_cycle_time_seconds",
 		Help: "gauge for cycle time",
 	},
-		[]string{
-			"deployment",
-			"namespace",
-		})
+		workloadLabelNames)
 
 	if !dryrun {
 		prometheus.MustRegister(collectors.CycleTimeGauge)
 	}
 
+	collectors.CycleTimeHistogram = *prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dora_cycle_time_distribution_seconds",
+		Help:    "histogram for cycle time",
+		Buckets: options.CycleTimeBuckets,
+	},
+		workloadLabelNames)
+
+	if !dryrun {
+		prometheus.MustRegister(collectors.CycleTimeHistogram)
+	}
+
+	collectors.TimeToRecoveryHistogram = *prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dora_time_to_recovery_distribution_seconds",
+		Help:    "histogram for time to recovery",
+		Buckets: options.TimeToRecoveryBuckets,
+	},
+		recoveryLabelNames)
+
+	if !dryrun {
+		prometheus.MustRegister(collectors.TimeToRecoveryHistogram)
+	}
+
+	collectors.LeadTimeHistogram = *prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dora_lead_time_seconds",
+		Help:    "histogram for lead time for changes, from commit to successful deployment",
+		Buckets: options.LeadTimeBuckets,
+	},
+		workloadLabelNames)
+
+	if !dryrun {
+		prometheus.MustRegister(collectors.LeadTimeHistogram)
+	}
+
+	collectors.RolloutDurationHistogram = *prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "dora_rollout_duration_seconds",
+		Help:    "histogram for the duration of automatically detected successful rollouts",
+		Buckets: options.CycleTimeBuckets,
+	},
+		workloadLabelNames)
+
+	if !dryrun {
+		prometheus.MustRegister(collectors.RolloutDurationHistogram)
+	}
+
+	collectors.DeploymentEventCounter = *prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dora_deployment_events_total",
+		Help: "counter for reported deployments, classified by outcome, image change and transition",
+	},
+		append([]string{"outcome", "image_change", "transition"}, workloadLabelNames...))
+
+	if !dryrun {
+		prometheus.MustRegister(collectors.DeploymentEventCounter)
+	}
+
+	collectors.ClampedCounter = *prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dora_clamped_observations_total",
+		Help: "counter for cycle times and times to recovery capped at their maximum",
+	},
+		append([]string{"metric"}, workloadLabelNames...))
+
+	if !dryrun {
+		prometheus.MustRegister(collectors.ClampedCounter)
+	}
+
+	collectors.ChangeFailureCounter = *prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dora_change_failures_total",
+		Help: "counter for successful deployments later blamed for an incident",
+	},
+		append([]string{"source"}, workloadLabelNames...))
+
+	if !dryrun {
+		prometheus.MustRegister(collectors.ChangeFailureCounter)
+	}
+
+	collectors.PerformanceTierGauge = *prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dora_performance_tier",
+		Help: "Accelerate performance tier of a service over the rolling window: 4 elite, 3 high, 2 medium, 1 low",
+	},
+		[]string{"metric", "team", "service"})
+
+	if !dryrun {
+		prometheus.MustRegister(collectors.PerformanceTierGauge)
+	}
+
+	collectors.LeaderGauge = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "dora_controller_leader",
+		Help: "1 if this controller replica processes deployments, 0 for a follower",
+	})
+
+	if !dryrun {
+		prometheus.MustRegister(collectors.LeaderGauge)
+	}
+
 	return nil
 }
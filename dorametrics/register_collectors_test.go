@@ -0,0 +1,34 @@
+package dorametrics
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBuckets(t *testing.T) {
+	var tests = []struct {
+		description string
+		list        string
+		expected    []float64
+		valid       bool
+	}{
+		{"single", "60", []float64{60}, true},
+		{"several", "30, 60,300.5", []float64{30, 60, 300.5}, true},
+		{"trailing_comma", "30,60,", []float64{30, 60}, true},
+		{"empty", "", nil, false},
+		{"not_a_number", "30,soon", nil, false},
+		{"unsorted", "300,60", nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			buckets, err := ParseBuckets(test.list)
+			if (err == nil) != test.valid {
+				t.Fatalf("Unexpected error value '%v' for list '%s'", err, test.list)
+			}
+			if !reflect.DeepEqual(buckets, test.expected) {
+				t.Errorf("Unexpected buckets %v; expected %v", buckets, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package dorametrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Report is a deployment outcome a pipeline records as annotations on its workload
+type Report struct {
+	Kind             string
+	Namespace        string
+	Name             string
+	StartedAt        int64 // unix seconds, when the pipeline was triggered
+	Success          bool
+	CommitSHA        string
+	CommitTimestamps []int64
+	Window           time.Duration // how long the controller may take to pick the report up
+}
+
+// ParseKind accepts a workload resource in singular or plural, e.g.
+// "deployment" or "deployments"
+func ParseKind(resource string) (string, error) {
+	resource = strings.ToLower(strings.TrimSpace(resource))
+	if kind, ok := kindsByResource[resource]; ok {
+		return kind, nil
+	}
+	if kind, ok := kindsByResource[resource+"s"]; ok {
+		return kind, nil
+	}
+	return "", fmt.Errorf("unsupported resource %s", resource)
+}
+
+// Annotations returns the annotations that report the deployment as of now
+func (r Report) Annotations(now time.Time) (map[string]string, error) {
+	if r.StartedAt <= 0 {
+		return nil, fmt.Errorf("start time is required")
+	}
+	cycleTime := now.Unix() - r.StartedAt
+	if cycleTime < 0 {
+		return nil, fmt.Errorf("start time %d is in the future", r.StartedAt)
+	}
+	if r.Window <= 0 {
+		return nil, fmt.Errorf("report window must be positive")
+	}
+
+	annotations := map[string]string{
+		fmt.Sprintf("%s/%s", annotationPrefix, annotationNameReportBefore): strconv.FormatInt(now.Add(r.Window).Unix(), 10),
+		fmt.Sprintf("%s/%s", annotationPrefix, annotationNameCycleTime):    strconv.FormatInt(cycleTime, 10),
+		fmt.Sprintf("%s/%s", annotationPrefix, annotationNameSuccess):      strconv.FormatBool(r.Success),
+	}
+	if len(r.CommitSHA) > 0 {
+		annotations[fmt.Sprintf("%s/%s", annotationPrefix, annotationNameCommitSHA)] = r.CommitSHA
+	}
+	for i, timestamp := range r.CommitTimestamps {
+		if timestamp <= 0 || timestamp > now.Unix() {
+			return nil, fmt.Errorf("invalid commit timestamp %d", timestamp)
+		}
+		if i == 0 {
+			annotations[fmt.Sprintf("%s/%s", annotationPrefix, annotationNameCommitTimestamp)] = strconv.FormatInt(timestamp, 10)
+			continue
+		}
+		others := annotations[fmt.Sprintf("%s/%s", annotationPrefix, annotationNameCommitTimestamps)]
+		if len(others) > 0 {
+			others += ","
+		}
+		annotations[fmt.Sprintf("%s/%s", annotationPrefix, annotationNameCommitTimestamps)] = others + strconv.FormatInt(timestamp, 10)
+	}
+	return annotations, nil
+}
+
+// ReportPatch returns the merge patch setting the annotations; commit
+// annotations left over from an earlier report are removed
+func ReportPatch(annotations map[string]string) ([]byte, error) {
+	values := map[string]interface{}{}
+	for _, name := range []string{annotationNameCommitSHA, annotationNameCommitTimestamp, annotationNameCommitTimestamps} {
+		values[fmt.Sprintf("%s/%s", annotationPrefix, name)] = nil
+	}
+	for name, value := range annotations {
+		values[name] = value
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": values},
+	})
+}
+
+// ApplyReport patches the report onto the workload in a single request, so
+// the controller never sees a partial report
+func ApplyReport(ctx context.Context, clientset kubernetes.Interface, dynamicClient dynamic.Interface, report Report, now time.Time) error {
+	annotations, err := report.Annotations(now)
+	if err != nil {
+		return err
+	}
+	patch, err := ReportPatch(annotations)
+	if err != nil {
+		return err
+	}
+
+	options := metav1.PatchOptions{FieldManager: "dora-metrics"}
+	switch report.Kind {
+	case KindDeployment:
+		_, err = clientset.AppsV1().Deployments(report.Namespace).Patch(ctx, report.Name, types.MergePatchType, patch, options)
+	case KindStatefulSet:
+		_, err = clientset.AppsV1().StatefulSets(report.Namespace).Patch(ctx, report.Name, types.MergePatchType, patch, options)
+	case KindDaemonSet:
+		_, err = clientset.AppsV1().DaemonSets(report.Namespace).Patch(ctx, report.Name, types.MergePatchType, patch, options)
+	case KindRollout:
+		_, err = dynamicClient.Resource(RolloutResource).Namespace(report.Namespace).Patch(ctx, report.Name, types.MergePatchType, patch, options)
+	default:
+		return fmt.Errorf("unsupported kind %s", report.Kind)
+	}
+	if err != nil {
+		return fmt.Errorf("can't annotate %s %s in namespace %s: %v", report.Kind, report.Name, report.Namespace, err)
+	}
+	return nil
+}
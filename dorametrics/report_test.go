@@ -0,0 +1,94 @@
+package dorametrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReportAnnotations(t *testing.T) {
+	now := time.Unix(1626600000, 0)
+	var tests = []struct {
+		description string
+		report      Report
+		expected    map[string]string
+		valid       bool
+	}{
+		{
+			"success",
+			Report{StartedAt: 1626599875, Success: true, Window: 10 * time.Minute},
+			map[string]string{
+				"dora-controller/report-before": "1626600600",
+				"dora-controller/cycle-time":    "125",
+				"dora-controller/success":       "true",
+			},
+			true,
+		},
+		{
+			"failure_with_commits",
+			Report{StartedAt: 1626599875, Window: time.Minute, CommitSHA: "4f2a9c1", CommitTimestamps: []int64{1626590000, 1626580000, 1626585000}},
+			map[string]string{
+				"dora-controller/report-before":     "1626600060",
+				"dora-controller/cycle-time":        "125",
+				"dora-controller/success":           "false",
+				"dora-controller/commit-sha":        "4f2a9c1",
+				"dora-controller/commit-timestamp":  "1626590000",
+				"dora-controller/commit-timestamps": "1626580000,1626585000",
+			},
+			true,
+		},
+		{"no_start", Report{Success: true, Window: time.Minute}, nil, false},
+		{"future_start", Report{StartedAt: 1626600001, Window: time.Minute}, nil, false},
+		{"no_window", Report{StartedAt: 1626599875}, nil, false},
+		{"future_commit", Report{StartedAt: 1626599875, Window: time.Minute, CommitTimestamps: []int64{1626600001}}, nil, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			annotations, err := test.report.Annotations(now)
+			if (err == nil) != test.valid {
+				t.Fatalf("Unexpected error %v; expected valid=%t", err, test.valid)
+			}
+			if len(annotations) != len(test.expected) {
+				t.Fatalf("Unexpected annotations %v; expected %v", annotations, test.expected)
+			}
+			for name, value := range test.expected {
+				if annotations[name] != value {
+					t.Errorf("Unexpected annotation %s=%s; expected %s", name, annotations[name], value)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyReport(t *testing.T) {
+	d := deployment("server-a", 2, 2, map[string]string{
+		"dora-controller/commit-timestamps": "1626500000",
+		"owner":                             "payments",
+	})
+	clientset := fake.NewSimpleClientset(d)
+	report := Report{Kind: KindDeployment, Namespace: "default", Name: "server-a", StartedAt: 1626599875, Success: true, Window: time.Minute}
+	if err := ApplyReport(context.Background(), clientset, nil, report, time.Unix(1626600000, 0)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	patched, err := clientset.AppsV1().Deployments("default").Get(context.Background(), "server-a", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	annotations := patched.GetAnnotations()
+	if annotations["dora-controller/report-before"] != "1626600060" || annotations["dora-controller/success"] != "true" || annotations["owner"] != "payments" {
+		t.Errorf("Unexpected annotations %v", annotations)
+	}
+	if _, ok := annotations["dora-controller/commit-timestamps"]; ok {
+		t.Errorf("Expected commit timestamps of the previous report to be removed")
+	}
+
+	report.Name = "server-b"
+	if err := ApplyReport(context.Background(), clientset, nil, report, time.Unix(1626600000, 0)); err == nil {
+		t.Errorf("Expected an error for a missing workload")
+	}
+}
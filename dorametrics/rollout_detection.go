@@ -0,0 +1,77 @@
+package dorametrics
+
+import (
+	"fmt"
+	"log"
+
+	au "github.com/logrusorgru/aurora"
+)
+
+// annotationNameAutoDetect opts a workload into rollout detection
+const annotationNameAutoDetect = "auto-detect"
+
+// autoDetectEnabled checks the workload annotation and the config file;
+// CI annotations take precedence
+func (c *Controller) autoDetectEnabled(kind string, namespace string, name string, annotations map[string]string) bool {
+	// workloads reported by CI must not count each deployment twice
+	if len(annotations[fmt.Sprintf("%s/%s", annotationPrefix, annotationNameReportBefore)]) > 0 {
+		return false
+	}
+	if annotations[fmt.Sprintf("%s/%s", annotationPrefix, annotationNameAutoDetect)] == "true" {
+		return true
+	}
+	if c.Config == nil {
+		return false
+	}
+	target, ok := c.Config.findTarget(kind, namespace, name)
+	return ok && target.AutoDetect
+}
+
+// detectRollout reports deployments for workloads without CI annotations:
+// a revision change starts a rollout, which succeeds once the new revision
+// has converged or fails when the workload gives up on it. Callers hold
+// c.Mutex; the return value tells whether the workload's state changed.
+func (c *Controller) detectRollout(lookupKey string, workload Workload, now int64) bool {
+	info := c.State[lookupKey]
+	revision := workload.Revision()
+	changed := false
+
+	if revision != info.Revision {
+		// the first revision we see is a baseline, not a rollout
+		if len(info.Revision) > 0 && info.RolloutStart == 0 {
+			log.Println(fmt.Sprintf("%s: detected rollout of revision %s for %s %s in namespace %s", au.Bold(au.Cyan("INFO")), revision, workload.Kind(), au.Bold(workload.GetName()), au.Bold(workload.GetNamespace())))
+			info.RolloutStart = now
+		}
+		// a rollout superseded before finishing keeps its start time
+		info.Revision = revision
+		changed = true
+	}
+
+	if info.RolloutStart > 0 {
+		complete, failed := workload.RolloutStatus()
+		if complete || failed {
+			duration := now - info.RolloutStart
+			info.RolloutStart = 0
+			// reportDeployment updates the state too
+			c.State[lookupKey] = info
+			c.reportDeployment(DeploymentEvent{
+				Service:    workload.GetName(),
+				Namespace:  workload.GetNamespace(),
+				Kind:       workload.Kind(),
+				Success:    complete,
+				FinishedAt: now,
+			})
+			if complete {
+				log.Println(fmt.Sprintf("%s: rollout of %s %s in namespace %s took %d seconds", au.Bold(au.Cyan("INFO")), workload.Kind(), au.Bold(workload.GetName()), au.Bold(workload.GetNamespace()), au.Bold(duration)))
+				metricLabels := c.metricLabels(workload.GetName(), workload.GetNamespace(), workload.Kind())
+				c.Collectors.RolloutDurationHistogram.With(metricLabels).Observe(float64(duration))
+			}
+			return true
+		}
+	}
+
+	if changed {
+		c.State[lookupKey] = info
+	}
+	return changed
+}
@@ -0,0 +1,135 @@
+package dorametrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// rolloutDeployment is an opted-in deployment at the given revision and rollout progress
+func rolloutDeployment(revision string, generation int64, observedGeneration int64, updated int32, available int32, deadlineExceeded bool) *appsv1.Deployment {
+	d := deployment("server-a", 2, available, map[string]string{
+		"dora-controller/auto-detect":       "true",
+		"deployment.kubernetes.io/revision": revision,
+	})
+	d.Generation = generation
+	d.Status.ObservedGeneration = observedGeneration
+	d.Status.Replicas = 2
+	d.Status.UpdatedReplicas = updated
+	d.Status.AvailableReplicas = available
+	if deadlineExceeded {
+		d.Status.Conditions = []appsv1.DeploymentCondition{{
+			Type:   appsv1.DeploymentProgressing,
+			Status: corev1.ConditionFalse,
+			Reason: "ProgressDeadlineExceeded",
+		}}
+	}
+	return d
+}
+
+func TestDeploymentRolloutStatus(t *testing.T) {
+	var tests = []struct {
+		description string
+		deployment  *appsv1.Deployment
+		complete    bool
+		failed      bool
+	}{
+		{"converged", rolloutDeployment("2", 2, 2, 2, 2, false), true, false},
+		{"in progress", rolloutDeployment("2", 2, 2, 1, 2, false), false, false},
+		{"not yet observed", rolloutDeployment("2", 3, 2, 2, 2, false), false, false},
+		{"deadline exceeded", rolloutDeployment("2", 2, 2, 1, 1, true), false, true},
+		{"stale deadline condition", rolloutDeployment("3", 3, 2, 1, 1, true), false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			complete, failed := deploymentWorkload{test.deployment}.RolloutStatus()
+			if complete != test.complete || failed != test.failed {
+				t.Errorf("Unexpected status complete=%t failed=%t; expected complete=%t failed=%t", complete, failed, test.complete, test.failed)
+			}
+		})
+	}
+}
+
+func TestDetectRollout(t *testing.T) {
+	c := newTestController(t)
+	labels := c.metricLabels("server-a", "default", KindDeployment)
+	key := "Deployment/default/server-a"
+	sync := func(d *appsv1.Deployment) {
+		c.Indexers[KindDeployment].Add(d)
+		if err := c.syncToStdout(key); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	// the first revision is a baseline
+	sync(rolloutDeployment("1", 1, 1, 2, 2, false))
+	if c.State[key].Revision != "1" || c.State[key].RolloutStart != 0 {
+		t.Fatalf("Unexpected state %+v after baseline", c.State[key])
+	}
+
+	// new revision rolls out and converges
+	sync(rolloutDeployment("2", 2, 2, 1, 2, false))
+	if c.State[key].RolloutStart == 0 {
+		t.Fatalf("Expected rollout to be in progress")
+	}
+	sync(rolloutDeployment("2", 2, 2, 2, 2, false))
+	if value := testutil.ToFloat64(c.Collectors.SuccessCounter.With(labels)); value != 1 {
+		t.Errorf("Unexpected success count %v; expected 1", value)
+	}
+	if count := sampleCount(t, c.Collectors.RolloutDurationHistogram.With(labels)); count != 1 {
+		t.Errorf("Unexpected number of rollout durations %d; expected 1", count)
+	}
+
+	// scaling alone is not a rollout
+	sync(rolloutDeployment("2", 3, 3, 2, 2, false))
+	if c.State[key].RolloutStart != 0 {
+		t.Errorf("Expected no rollout after scaling")
+	}
+
+	// next revision gets stuck
+	sync(rolloutDeployment("3", 4, 4, 1, 2, false))
+	sync(rolloutDeployment("3", 4, 4, 1, 1, true))
+	if value := testutil.ToFloat64(c.Collectors.FailureCounter.With(labels)); value != 1 {
+		t.Errorf("Unexpected failure count %v; expected 1", value)
+	}
+	if c.State[key].RolloutStart != 0 {
+		t.Errorf("Expected failed rollout to be finished")
+	}
+	// later updates of the failed revision don't count again
+	sync(rolloutDeployment("3", 4, 4, 1, 1, true))
+	if value := testutil.ToFloat64(c.Collectors.FailureCounter.With(labels)); value != 1 {
+		t.Errorf("Unexpected failure count %v; expected 1", value)
+	}
+	if value := testutil.ToFloat64(c.Collectors.SuccessCounter.With(labels)); value != 1 {
+		t.Errorf("Unexpected success count %v; expected 1", value)
+	}
+}
+
+func TestAutoDetectEnabled(t *testing.T) {
+	var tests = []struct {
+		description string
+		annotations map[string]string
+		target      bool
+		expected    bool
+	}{
+		{"annotation", map[string]string{"dora-controller/auto-detect": "true"}, false, true},
+		{"target", nil, true, true},
+		{"neither", nil, false, false},
+		{"annotation_and_ci", map[string]string{"dora-controller/auto-detect": "true", "dora-controller/report-before": "1646128800"}, false, false},
+		{"target_and_ci", map[string]string{"dora-controller/report-before": "1646128800"}, true, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			c := newTestController(t)
+			c.Config = &ControllerConfig{Targets: []Target{{Name: "server-a", Namespace: "default", AutoDetect: test.target}}}
+			if actual := c.autoDetectEnabled(KindDeployment, "default", "server-a", test.annotations); actual != test.expected {
+				t.Errorf("Unexpected autoDetectEnabled %t; expected %t", actual, test.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package dorametrics
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const stateStoreDataKey = "state.json"
+
+// maxConfigMapStateBytes leaves room below the 1MiB object size limit for the ConfigMap's metadata
+const maxConfigMapStateBytes = 1000 * 1024
+
+// StateStore persists controller state across restarts
+type StateStore interface {
+	Load() (SavedState, error)
+	Save(state SavedState) error
+}
+
+// SavedState is the serialised form shared by all state stores
+type SavedState struct {
+	State     map[string]DeploymentInfo `json:"state"`
+	Dedup     map[string]string         `json:"dedup"`
+	Incidents map[string]IncidentInfo   `json:"incidents"`
+}
+
+func emptyState() SavedState {
+	return SavedState{
+		State:     map[string]DeploymentInfo{},
+		Dedup:     map[string]string{},
+		Incidents: map[string]IncidentInfo{},
+	}
+}
+
+// NewStateStore returns the store for the given kind ("configmap", "file" or "none")
+func NewStateStore(kind string, clientset kubernetes.Interface, namespace string, name string, path string) (StateStore, error) {
+	switch kind {
+	case "", "none":
+		return nil, nil
+	case "configmap":
+		if len(namespace) == 0 {
+			return nil, fmt.Errorf("configmap state store requires a namespace")
+		}
+		return &ConfigMapStateStore{Clientset: clientset, Namespace: namespace, Name: name}, nil
+	case "file":
+		return &FileStateStore{Path: path}, nil
+	}
+	return nil, fmt.Errorf("unknown state store %s", kind)
+}
+
+// unmarshalState parses saved state; maps missing from older saves are left empty
+func unmarshalState(data []byte) (SavedState, error) {
+	state := emptyState()
+	err := json.Unmarshal(data, &state)
+	if err != nil {
+		return SavedState{}, err
+	}
+	if state.State == nil {
+		state.State = map[string]DeploymentInfo{}
+	}
+	if state.Dedup == nil {
+		state.Dedup = map[string]string{}
+	}
+	if state.Incidents == nil {
+		state.Incidents = map[string]IncidentInfo{}
+	}
+	return state, nil
+}
+
+// FileStateStore keeps state in a local JSON file (out-of-cluster runs)
+type FileStateStore struct {
+	Path string
+}
+
+// Load reads the state file; a missing file yields empty state
+func (s *FileStateStore) Load() (SavedState, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return emptyState(), nil
+	}
+	if err != nil {
+		return SavedState{}, fmt.Errorf("can't read state file %s: %v", s.Path, err)
+	}
+	state, err := unmarshalState(data)
+	if err != nil {
+		return SavedState{}, fmt.Errorf("can't parse state file %s: %v", s.Path, err)
+	}
+	return state, nil
+}
+
+// Save writes the state file via a temporary file so readers never see partial content
+func (s *FileStateStore) Save(state SavedState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.Path), filepath.Base(s.Path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("can't create temporary state file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("can't write state file %s: %v", s.Path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("can't write state file %s: %v", s.Path, err)
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+// ConfigMapStateStore keeps state in a ConfigMap in the controller's namespace
+type ConfigMapStateStore struct {
+	Clientset kubernetes.Interface
+	Namespace string
+	Name      string
+}
+
+// Load reads the state ConfigMap; a missing ConfigMap yields empty state
+func (s *ConfigMapStateStore) Load() (SavedState, error) {
+	configMap, err := s.Clientset.CoreV1().ConfigMaps(s.Namespace).Get(context.TODO(), s.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return emptyState(), nil
+	}
+	if err != nil {
+		return SavedState{}, fmt.Errorf("can't read configmap %s/%s: %v", s.Namespace, s.Name, err)
+	}
+	data, ok := configMap.Data[stateStoreDataKey]
+	if !ok {
+		return emptyState(), nil
+	}
+	state, err := unmarshalState([]byte(data))
+	if err != nil {
+		return SavedState{}, fmt.Errorf("can't parse configmap %s/%s: %v", s.Namespace, s.Name, err)
+	}
+	return state, nil
+}
+
+// Save creates or updates the state ConfigMap
+func (s *ConfigMapStateStore) Save(state SavedState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if len(data) > maxConfigMapStateBytes {
+		return fmt.Errorf("state of %d workloads and %d incidents takes %d bytes, more than configmap %s/%s can hold; use the file state store", len(state.State), len(state.Incidents), len(data), s.Namespace, s.Name)
+	}
+	configMaps := s.Clientset.CoreV1().ConfigMaps(s.Namespace)
+	configMap, err := configMaps.Get(context.TODO(), s.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		configMap = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: s.Name, Namespace: s.Namespace},
+			Data:       map[string]string{stateStoreDataKey: string(data)},
+		}
+		_, err = configMaps.Create(context.TODO(), configMap, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("can't read configmap %s/%s: %v", s.Namespace, s.Name, err)
+	}
+	if configMap.Data == nil {
+		configMap.Data = map[string]string{}
+	}
+	configMap.Data[stateStoreDataKey] = string(data)
+	_, err = configMaps.Update(context.TODO(), configMap, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,119 @@
+package dorametrics
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestStateStores(t *testing.T) {
+	var tests = []struct {
+		description string
+		store       StateStore
+	}{
+		{"file", &FileStateStore{Path: filepath.Join(t.TempDir(), "state.json")}},
+		{"configmap", &ConfigMapStateStore{Clientset: fake.NewSimpleClientset(), Namespace: "kube-monitoring", Name: "dora-metrics-state"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			saved, err := test.store.Load()
+			if err != nil {
+				t.Fatalf("Unexpected error loading empty store: %v", err)
+			}
+			if len(saved.State) != 0 || len(saved.Dedup) != 0 || len(saved.Incidents) != 0 {
+				t.Fatalf("Expected empty state; got %+v", saved)
+			}
+
+			// save twice to cover both create and update
+			saved.State["Deployment/default/server-a"] = DeploymentInfo{Name: "server-a", Namespace: "default", Kind: "Deployment", Replicas: 2, ErrorStart: 1626600000}
+			saved.Dedup["Deployment/default/server-a"] = "1626600056"
+			saved.Incidents["cdevents/INC-1"] = IncidentInfo{ID: "INC-1", Source: "cdevents", Service: "server-a", Namespace: "default", Kind: "Deployment", Start: 1626600100}
+			for i := 0; i < 2; i++ {
+				err = test.store.Save(saved)
+				if err != nil {
+					t.Fatalf("Unexpected error saving state: %v", err)
+				}
+			}
+
+			saved, err = test.store.Load()
+			if err != nil {
+				t.Fatalf("Unexpected error loading state: %v", err)
+			}
+			if saved.State["Deployment/default/server-a"].ErrorStart != 1626600000 {
+				t.Errorf("Unexpected ErrorStart %d; expected 1626600000", saved.State["Deployment/default/server-a"].ErrorStart)
+			}
+			if saved.Dedup["Deployment/default/server-a"] != "1626600056" {
+				t.Errorf("Unexpected dedup value '%s'; expected '1626600056'", saved.Dedup["Deployment/default/server-a"])
+			}
+			if saved.Incidents["cdevents/INC-1"].Start != 1626600100 {
+				t.Errorf("Unexpected incident start %d; expected 1626600100", saved.Incidents["cdevents/INC-1"].Start)
+			}
+		})
+	}
+}
+
+func TestConfigMapStateStoreLimit(t *testing.T) {
+	store := &ConfigMapStateStore{Clientset: fake.NewSimpleClientset(), Namespace: "kube-monitoring", Name: "dora-metrics-state"}
+	state := emptyState()
+	for i := 0; i < 10000; i++ {
+		key := fmt.Sprintf("Deployment/default/server-%d", i)
+		state.State[key] = DeploymentInfo{Name: fmt.Sprintf("server-%d", i), Namespace: "default", Kind: KindDeployment}
+	}
+	if err := store.Save(state); err == nil {
+		t.Errorf("Expected an error for state exceeding the configmap size limit")
+	}
+}
+
+func TestStatePersistence(t *testing.T) {
+	store := &FileStateStore{Path: filepath.Join(t.TempDir(), "state.json")}
+	saved := emptyState()
+	saved.State["Deployment/default/server-b"] = DeploymentInfo{Name: "server-b", Namespace: "default", Kind: KindDeployment, Replicas: 2, ErrorStart: 1626600000, ErrorState: availabilityDown}
+	saved.Dedup["Deployment/default/server-b"] = "1626600056"
+	saved.Dedup["Deployment/default/server-c"] = "1626600056"
+	saved.Incidents["cdevents/INC-1"] = IncidentInfo{ID: "INC-1", Source: "cdevents", Service: "server-b", Namespace: "default", Kind: KindDeployment, Start: time.Now().Unix()}
+	saved.Incidents["cdevents/INC-0"] = IncidentInfo{ID: "INC-0", Source: "cdevents", Service: "server-b", Namespace: "default", Kind: KindDeployment, Start: 1626600000}
+	if err := store.Save(saved); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// state is restored on startup
+	c := newTestController(t)
+	c.Store = store
+	c.reloadState()
+	if c.State["Deployment/default/server-b"].ErrorStart != 1626600000 || len(c.Incidents) != 2 {
+		t.Fatalf("Unexpected restored state %+v, incidents %+v", c.State, c.Incidents)
+	}
+
+	// an outage is written through, together with later changes
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		c.runStatePersistence(stop)
+		close(done)
+	}()
+	c.Indexers[KindDeployment].Add(deployment("server-a", 2, 0, nil))
+	if err := c.syncToStdout("Deployment/default/server-a"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	close(stop)
+	<-done
+
+	saved, err := store.Load()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if saved.State["Deployment/default/server-a"].ErrorStart == 0 {
+		t.Errorf("Expected the outage of server-a to be saved; got %+v", saved.State)
+	}
+	// dedup entries without state and stale incidents are pruned
+	if _, ok := saved.Dedup["Deployment/default/server-c"]; ok || len(saved.Dedup) != 1 {
+		t.Errorf("Unexpected dedup entries %+v", saved.Dedup)
+	}
+	if _, ok := saved.Incidents["cdevents/INC-1"]; !ok || len(saved.Incidents) != 1 {
+		t.Errorf("Unexpected incidents %+v", saved.Incidents)
+	}
+}
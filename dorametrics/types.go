@@ -1,7 +1,9 @@
 package dorametrics
 
 import (
+	"crypto/sha256"
 	"sync"
+	"time"
 
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/cache"
@@ -13,41 +15,115 @@ import (
 type ControllerConfig struct {
 	Targets []Target `json:"deployments"`
 	Stage   string   `json:"stage"`
+	Mode    string   `json:"mode"` // "augment" (default) or "restrict"
+
+	AvailabilityThreshold    float64 `json:"availabilityThreshold,omitempty"`
+	OpenGracePeriodSeconds   int64   `json:"openGracePeriodSeconds,omitempty"`
+	CloseGracePeriodSeconds  int64   `json:"closeGracePeriodSeconds,omitempty"`
+	MaxCycleTimeSeconds      *int64  `json:"maxCycleTimeSeconds,omitempty"` // 0 disables the cap
+	MaxTimeToRecoverySeconds *int64  `json:"maxTimeToRecoverySeconds,omitempty"`
+
+	ChangeFailureWindowSeconds *int64 `json:"changeFailureWindowSeconds,omitempty"` // 0 blames no deployment
+
+	checksum [sha256.Size]byte // of the file content, to detect changes
 }
 
 type Target struct {
-	Name      string `json:"name"`
-	Namespace string `json:"namespace"`
-	Team      string `json:"team"`
-	Alert     bool
+	Name        string `json:"name"`
+	Namespace   string `json:"namespace"`
+	Kind        string `json:"kind,omitempty"`
+	Team        string `json:"team"`
+	Service     string `json:"service,omitempty"`
+	Environment string `json:"environment,omitempty"`
+	Alert       bool   `json:"alert"`
+	AutoDetect  bool   `json:"autoDetect,omitempty"`
+
+	AvailabilityThreshold    float64 `json:"availabilityThreshold,omitempty"`
+	OpenGracePeriodSeconds   int64   `json:"openGracePeriodSeconds,omitempty"`
+	CloseGracePeriodSeconds  int64   `json:"closeGracePeriodSeconds,omitempty"`
+	MaxCycleTimeSeconds      *int64  `json:"maxCycleTimeSeconds,omitempty"` // 0 disables the cap
+	MaxTimeToRecoverySeconds *int64  `json:"maxTimeToRecoverySeconds,omitempty"`
+
+	ChangeFailureWindowSeconds *int64 `json:"changeFailureWindowSeconds,omitempty"`
 }
 
 // Controller represents the controller state
 type Controller struct {
-	Indexer    cache.Indexer
+	Indexers   map[string]cache.Indexer // map[KIND]Indexer
+	Namespaces cache.Indexer            // optional, for team and environment labels
 	Queue      workqueue.RateLimitingInterface
-	Informer   cache.Controller
+	Informers  []cache.Controller
 	Clientset  kubernetes.Interface
 	Mutex      *sync.Mutex
-	State      map[string]DeploymentInfo // map[NAMESPACE:NAME]DeploymentInfo
-	Dedup      map[string]string         // map[NAMESPACE:NAME]REPORT_BEFORE
+	State      map[string]DeploymentInfo // map[KIND/NAMESPACE/NAME]DeploymentInfo
+	Dedup      map[string]string         // map[KIND/NAMESPACE/NAME]REPORT_BEFORE, map[KIND/NAMESPACE/NAME#ingest]EVENT_IDENTITY
+	Incidents  map[string]IncidentInfo   // map[SOURCE/ID]IncidentInfo
+	Store      StateStore
+	Config     *ControllerConfig
+	Alerter    *Alerter
 	Debug      bool
 	Collectors *Collectors
+
+	// the informers list workloads without the enabled label, as configured
+	// targets in augment mode need; otherwise the API server filters them
+	WatchesUnlabelled bool
+
+	DefaultTeam        string
+	DefaultEnvironment string
+
+	DefaultAvailabilityThreshold float64
+	DefaultOpenGracePeriod       time.Duration
+	DefaultCloseGracePeriod      time.Duration
+	DefaultChangeFailureWindow   time.Duration // how long pod outages are blamed on the last deployment
+
+	MaxCycleTimeSeconds      int64 // 0 disables the cap
+	MaxTimeToRecoverySeconds int64
+
+	DeletionRetention time.Duration // how long to keep series of deleted workloads
+	PersistInterval   time.Duration // shortest time between two writes to Store
+	EventLog          EventLog
+
+	EventStore        *EventStore   // optional, for queries and performance tiers
+	PerformanceWindow time.Duration // rolling window of the performance tiers
+
+	persistPending chan struct{} // signals runStatePersistence that state changed
+	leading        int32         // 1 while Lead runs, accessed atomically
 }
 
 // DeploymentInfo captures the information written to stdout
 type DeploymentInfo struct {
-	Name          string `json:"name"`
-	Namespace     string `json:"namespace"`
-	Replicas      int32  `json:"replicas"`
-	ReadyReplicas int32  `json:"readyReplicas"`
-	ErrorStart    int64  `json:"errorStart"`
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	Replicas        int32  `json:"replicas"`
+	ReadyReplicas   int32  `json:"readyReplicas"`
+	ErrorStart      int64  `json:"errorStart"`
+	ErrorState      string `json:"errorState,omitempty"`   // down or degraded while ErrorStart > 0
+	PendingSince    int64  `json:"pendingSince,omitempty"` // when an outage started or ended, during the grace period
+	PendingState    string `json:"pendingState,omitempty"` // state of an outage waiting to open
+	DeletedAt       int64  `json:"deletedAt,omitempty"`    // when the workload was deleted, during the retention delay
+	Kind            string `json:"kind"`
+	Revision        string `json:"revision,omitempty"`        // last revision seen, for rollout detection
+	RolloutStart    int64  `json:"rolloutStart,omitempty"`    // 0 unless a detected rollout is in progress
+	Images          string `json:"images,omitempty"`          // comma-separated, as of the last reported deployment
+	PreviousFailure bool   `json:"previousFailure,omitempty"` // whether the last reported deployment failed
+	LastDeployedAt  int64  `json:"lastDeployedAt,omitempty"`  // when the last successful deployment was reported
+	ChangeFailureAt int64  `json:"changeFailureAt,omitempty"` // LastDeployedAt of the last deployment counted as a change failure
+	VerifyPending   string `json:"verifyPending,omitempty"`   // report-before of a reported success awaiting its pods
 }
 
 type Collectors struct {
-	CycleTimeGauge      prometheus.GaugeVec
-	TimeToRecoveryGauge prometheus.GaugeVec
-	SuccessCounter      prometheus.CounterVec
-	FailureCounter      prometheus.CounterVec
-	DowntimeCounter     prometheus.CounterVec
+	CycleTimeGauge           prometheus.GaugeVec
+	TimeToRecoveryGauge      prometheus.GaugeVec
+	CycleTimeHistogram       prometheus.HistogramVec
+	TimeToRecoveryHistogram  prometheus.HistogramVec
+	LeadTimeHistogram        prometheus.HistogramVec
+	RolloutDurationHistogram prometheus.HistogramVec
+	DeploymentEventCounter   prometheus.CounterVec
+	ClampedCounter           prometheus.CounterVec
+	ChangeFailureCounter     prometheus.CounterVec
+	SuccessCounter           prometheus.CounterVec
+	FailureCounter           prometheus.CounterVec
+	DowntimeCounter          prometheus.CounterVec
+	PerformanceTierGauge     prometheus.GaugeVec
+	LeaderGauge              prometheus.Gauge
 }
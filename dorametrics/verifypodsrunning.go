@@ -2,32 +2,100 @@ package dorametrics
 
 import (
 	"context"
-	"strings"
 
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 )
 
-func verifyPodsRunning(clientset kubernetes.Interface, namespace string, name string, image string) bool {
-	pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), v1.ListOptions{})
-
+// verifyPodsRunning confirms that a workload runs its current pod template:
+// at least the desired number of its pods must be ready with the template's
+// images. Pods are found through the workload's selector and owner
+// references, so pods of similarly named workloads don't count. Containers
+// outside the template, such as injected sidecars, and init containers are
+// ignored.
+func verifyPodsRunning(clientset kubernetes.Interface, workload Workload) (bool, error) {
+	selector, err := metav1.LabelSelectorAsSelector(workload.PodSelector())
 	if err != nil {
-		return false
+		return false, err
 	}
+	listOptions := metav1.ListOptions{LabelSelector: selector.String()}
+	namespace := workload.GetNamespace()
 
-	running := 0
-	for _, pod := range pods.Items {
-		podName := pod.ObjectMeta.Name
-		if strings.HasPrefix(podName, name) {
-			for _, container := range pod.Spec.Containers {
-				if container.Image == image && pod.Status.Phase == "Running" {
-					running++
-				} else {
-					return false
-				}
+	// owners maps the UIDs of pod owners to whether they run the current revision;
+	// Deployments and Rollouts own their pods through ReplicaSets
+	owners := map[types.UID]bool{workload.GetUID(): true}
+	if workload.Kind() == KindDeployment || workload.Kind() == KindRollout {
+		replicaSets, err := clientset.AppsV1().ReplicaSets(namespace).List(context.TODO(), listOptions)
+		if err != nil {
+			return false, err
+		}
+		revision := workload.GetAnnotations()[deploymentRevisionAnnotation]
+		for i := range replicaSets.Items {
+			replicaSet := &replicaSets.Items[i]
+			owner := metav1.GetControllerOf(replicaSet)
+			if owner == nil || owner.UID != workload.GetUID() {
+				continue
 			}
+			owners[replicaSet.UID] = workload.Kind() != KindDeployment ||
+				len(revision) == 0 ||
+				replicaSet.Annotations[deploymentRevisionAnnotation] == revision
+		}
+	}
+
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), listOptions)
+	if err != nil {
+		return false, err
+	}
+
+	template := workload.PodTemplate()
+	ready := int32(0)
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		owner := metav1.GetControllerOf(pod)
+		if owner == nil || pod.DeletionTimestamp != nil {
+			continue
+		}
+		current, owned := owners[owner.UID]
+		if !owned {
+			continue
+		}
+		// pods of an earlier revision mean the rollout hasn't finished
+		if !current || !runsTemplate(pod, template) {
+			return false, nil
+		}
+		if podReady(pod) {
+			ready++
 		}
 	}
 
-	return running > 0
+	return ready >= workload.DesiredReplicas(), nil
+}
+
+// runsTemplate checks the images of the template's containers; other
+// containers may have been added by admission webhooks
+func runsTemplate(pod *corev1.Pod, template corev1.PodTemplateSpec) bool {
+	images := map[string]string{}
+	for _, container := range pod.Spec.Containers {
+		images[container.Name] = container.Image
+	}
+	for _, container := range template.Spec.Containers {
+		if images[container.Name] != container.Image {
+			return false
+		}
+	}
+	return true
+}
+
+func podReady(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
 }
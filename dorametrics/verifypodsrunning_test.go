@@ -3,9 +3,11 @@ package dorametrics
 import (
 	"testing"
 
+	appsv1 "k8s.io/api/apps/v1"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/fake"
 )
 
@@ -21,12 +23,86 @@ func podPhase(phase string) v1.PodPhase {
 	return v1.PodUnknown
 }
 
+// verifiedDeployment is a deployment with a selector, a template and a UID for pods to refer to
+func verifiedDeployment(name string, replicas int32, annotations map[string]string) *appsv1.Deployment {
+	d := deployment(name, replicas, replicas, annotations)
+	d.UID = types.UID(name + "-uid")
+	d.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}}
+	d.Spec.Template.Labels = map[string]string{"app": name}
+	d.Spec.Template.Spec.Containers = []v1.Container{{Name: "server", Image: "ubuntu"}}
+	return d
+}
+
+func controllerRef(uid string, kind string) []metav1.OwnerReference {
+	controller := true
+	return []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: kind, Name: uid, UID: types.UID(uid), Controller: &controller}}
+}
+
+// replicaSet is owned by the given deployment at the given revision
+func replicaSet(d *appsv1.Deployment, revision string) *appsv1.ReplicaSet {
+	return &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{
+		Name:            d.Name + "-" + revision,
+		Namespace:       d.Namespace,
+		UID:             types.UID(d.Name + "-" + revision),
+		Labels:          d.Spec.Template.Labels,
+		Annotations:     map[string]string{deploymentRevisionAnnotation: revision},
+		OwnerReferences: controllerRef(string(d.UID), "Deployment"),
+	}}
+}
+
+// pod belongs to ReplicaSet server-a-1 unless its owner is overridden
 func pod(phase string) *v1.Pod {
 	image := "ubuntu"
-	return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "server-a", Namespace: "default"}, Spec: v1.PodSpec{Containers: []v1.Container{{Image: image}}}, Status: v1.PodStatus{Phase: podPhase(phase)}}
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "server-a-1-abcde",
+			Namespace:       "default",
+			Labels:          map[string]string{"app": "server-a"},
+			OwnerReferences: controllerRef("server-a-1", "ReplicaSet"),
+		},
+		Spec: v1.PodSpec{Containers: []v1.Container{{Name: "server", Image: image}}},
+		Status: v1.PodStatus{
+			Phase:      podPhase(phase),
+			Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}},
+		},
+	}
+}
+
+// runningPods lets verifyPodsRunning confirm the given deployment
+func runningPods(d *appsv1.Deployment) []runtime.Object {
+	objs := []runtime.Object{replicaSet(d, "1")}
+	for i := int32(0); i < *d.Spec.Replicas; i++ {
+		p := pod("Running")
+		p.Name = d.Name + "-1-" + string(rune('a'+i))
+		p.Labels = d.Spec.Template.Labels
+		p.OwnerReferences = controllerRef(d.Name+"-1", "ReplicaSet")
+		objs = append(objs, p)
+	}
+	return objs
 }
 
 func TestVerifyPodsRunning(t *testing.T) {
+	notReady := pod("Running")
+	notReady.Status.Conditions = []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionFalse}}
+
+	sidecar := pod("Running")
+	sidecar.Spec.Containers = append(sidecar.Spec.Containers, v1.Container{Name: "istio-proxy", Image: "proxyv2"})
+	sidecar.Spec.InitContainers = []v1.Container{{Name: "init", Image: "busybox"}}
+
+	oldImage := pod("Running")
+	oldImage.Spec.Containers[0].Image = "debian"
+
+	// similar name and labels, but owned by another workload
+	gateway := pod("Pending")
+	gateway.Name = "server-a-gateway-1-abcde"
+	gateway.OwnerReferences = controllerRef("server-a-gateway-1", "ReplicaSet")
+
+	oldRevision := pod("Running")
+	oldRevision.Name = "server-a-0-abcde"
+	oldRevision.OwnerReferences = controllerRef("server-a-0", "ReplicaSet")
+
+	d := verifiedDeployment("server-a", 1, map[string]string{deploymentRevisionAnnotation: "1"})
+
 	var tests = []struct {
 		description string
 		result      bool
@@ -35,12 +111,21 @@ func TestVerifyPodsRunning(t *testing.T) {
 		{"pod_running", true, []runtime.Object{pod("Running")}},
 		{"pod_pending", false, []runtime.Object{pod("Pending")}},
 		{"pod_unknown", false, []runtime.Object{pod("Unknown")}},
+		{"pod_not_ready", false, []runtime.Object{notReady}},
+		{"pod_with_sidecar", true, []runtime.Object{sidecar}},
+		{"pod_with_old_image", false, []runtime.Object{oldImage}},
+		{"other_workload_ignored", true, []runtime.Object{pod("Running"), gateway}},
+		{"other_workload_only", false, []runtime.Object{gateway}},
+		{"old_revision_remaining", false, []runtime.Object{pod("Running"), oldRevision, replicaSet(d, "0")}},
 	}
 
 	for _, test := range tests {
 		t.Run(test.description, func(t *testing.T) {
-			client := fake.NewSimpleClientset(test.objs...)
-			result := verifyPodsRunning(client, "default", "server-a", "ubuntu")
+			client := fake.NewSimpleClientset(append(test.objs, replicaSet(d, "1"))...)
+			result, err := verifyPodsRunning(client, deploymentWorkload{d})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
 			expected := "success"
 			if test.result == false {
 				expected = "failure"
@@ -0,0 +1,68 @@
+package dorametrics
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"time"
+
+	au "github.com/logrusorgru/aurora"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+func fileChecksum(path string) ([sha256.Size]byte, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	return sha256.Sum256(bytes), nil
+}
+
+// WatchConfig re-reads the configuration file every interval and applies
+// changes without a restart; ConfigMap volumes swap a symlink rather than
+// writing in place, so we compare content rather than modification times
+func (c *Controller) WatchConfig(path string, interval time.Duration, stopCh <-chan struct{}) {
+	var rejected [sha256.Size]byte
+	wait.Until(func() {
+		current, err := fileChecksum(path)
+		if err != nil || current == rejected {
+			return
+		}
+		c.Mutex.Lock()
+		unchanged := c.Config != nil && c.Config.checksum == current
+		c.Mutex.Unlock()
+		if unchanged {
+			return
+		}
+
+		config, err := LoadConfig(path)
+		if err != nil {
+			// remember invalid content so we only complain once
+			rejected = current
+			log.Println(fmt.Sprintf("%s: keeping previous configuration: %v", au.Bold(au.Red("Error")), err))
+			return
+		}
+
+		c.Mutex.Lock()
+		c.Config = config
+		c.Mutex.Unlock()
+		log.Println(fmt.Sprintf("%s: reloaded configuration file %s with %d targets", au.Bold(au.Cyan("INFO")), path, len(config.Targets)))
+		if config.ExtendsTracking() && !c.WatchesUnlabelled {
+			log.Println(fmt.Sprintf("%s: targets without label %s are only watched after a restart", au.Bold(au.Red("Error")), EnabledLabel))
+		}
+
+		// workloads may have been added to or removed from the tracked set
+		c.requeueAll()
+	}, interval, stopCh)
+}
+
+// requeueAll queues every workload the informers know about
+func (c *Controller) requeueAll() {
+	for kind, indexer := range c.Indexers {
+		for _, key := range indexer.ListKeys() {
+			c.Queue.Add(kind + "/" + key)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package dorametrics
+
+import (
+	"fmt"
+	"strconv"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	KindDeployment  = "Deployment"
+	KindStatefulSet = "StatefulSet"
+	KindDaemonSet   = "DaemonSet"
+	KindRollout     = "Rollout"
+)
+
+// Workload abstracts the resource kinds the controller watches
+type Workload interface {
+	Kind() string
+	GetName() string
+	GetNamespace() string
+	GetLabels() map[string]string
+	GetAnnotations() map[string]string
+	DesiredReplicas() int32
+	ReadyReplicas() int32
+	// Revision changes whenever a new rollout starts
+	Revision() string
+	// RolloutStatus reports whether the current revision has finished
+	// rolling out, or has failed to
+	RolloutStatus() (complete bool, failed bool)
+	// Images lists the container images of the pod template
+	Images() []string
+	GetUID() types.UID
+	PodSelector() *metav1.LabelSelector
+	PodTemplate() corev1.PodTemplateSpec
+}
+
+func containerImages(spec corev1.PodSpec) []string {
+	var images []string
+	for _, container := range spec.Containers {
+		images = append(images, container.Image)
+	}
+	return images
+}
+
+const deploymentRevisionAnnotation = "deployment.kubernetes.io/revision"
+
+type deploymentWorkload struct {
+	*appsv1.Deployment
+}
+
+func (w deploymentWorkload) Kind() string { return KindDeployment }
+
+func (w deploymentWorkload) Images() []string { return containerImages(w.Spec.Template.Spec) }
+
+func (w deploymentWorkload) PodSelector() *metav1.LabelSelector { return w.Spec.Selector }
+
+func (w deploymentWorkload) PodTemplate() corev1.PodTemplateSpec { return w.Spec.Template }
+
+func (w deploymentWorkload) DesiredReplicas() int32 {
+	if w.Spec.Replicas == nil {
+		return 1
+	}
+	return *w.Spec.Replicas
+}
+
+func (w deploymentWorkload) ReadyReplicas() int32 { return w.Status.ReadyReplicas }
+
+// Revision uses the revision the deployment controller assigns to each new
+// pod template, since the generation also changes on scaling
+func (w deploymentWorkload) Revision() string {
+	if revision, ok := w.Annotations[deploymentRevisionAnnotation]; ok {
+		return revision
+	}
+	return strconv.FormatInt(w.Generation, 10)
+}
+
+func (w deploymentWorkload) RolloutStatus() (bool, bool) {
+	// conditions left over from the previous revision don't count
+	if w.Status.ObservedGeneration < w.Generation {
+		return false, false
+	}
+	for _, condition := range w.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Reason == "ProgressDeadlineExceeded" {
+			return false, true
+		}
+	}
+	desired := w.DesiredReplicas()
+	complete := w.Status.UpdatedReplicas == desired &&
+		w.Status.AvailableReplicas == desired &&
+		w.Status.Replicas == desired
+	return complete, false
+}
+
+type statefulSetWorkload struct {
+	*appsv1.StatefulSet
+}
+
+func (w statefulSetWorkload) Kind() string { return KindStatefulSet }
+
+func (w statefulSetWorkload) Images() []string { return containerImages(w.Spec.Template.Spec) }
+
+func (w statefulSetWorkload) PodSelector() *metav1.LabelSelector { return w.Spec.Selector }
+
+func (w statefulSetWorkload) PodTemplate() corev1.PodTemplateSpec { return w.Spec.Template }
+
+func (w statefulSetWorkload) DesiredReplicas() int32 {
+	if w.Spec.Replicas == nil {
+		return 1
+	}
+	return *w.Spec.Replicas
+}
+
+func (w statefulSetWorkload) ReadyReplicas() int32 { return w.Status.ReadyReplicas }
+
+func (w statefulSetWorkload) Revision() string { return w.Status.UpdateRevision }
+
+// RolloutStatus never reports failure: StatefulSets have no progress deadline
+func (w statefulSetWorkload) RolloutStatus() (bool, bool) {
+	desired := w.DesiredReplicas()
+	complete := w.Status.ObservedGeneration >= w.Generation &&
+		w.Status.CurrentRevision == w.Status.UpdateRevision &&
+		w.Status.UpdatedReplicas == desired &&
+		w.Status.ReadyReplicas == desired
+	return complete, false
+}
+
+type daemonSetWorkload struct {
+	*appsv1.DaemonSet
+}
+
+func (w daemonSetWorkload) Kind() string { return KindDaemonSet }
+
+func (w daemonSetWorkload) Images() []string { return containerImages(w.Spec.Template.Spec) }
+
+func (w daemonSetWorkload) PodSelector() *metav1.LabelSelector { return w.Spec.Selector }
+
+func (w daemonSetWorkload) PodTemplate() corev1.PodTemplateSpec { return w.Spec.Template }
+
+func (w daemonSetWorkload) DesiredReplicas() int32 { return w.Status.DesiredNumberScheduled }
+
+func (w daemonSetWorkload) ReadyReplicas() int32 { return w.Status.NumberReady }
+
+// Revision uses the generation, which only changes with the spec
+func (w daemonSetWorkload) Revision() string { return strconv.FormatInt(w.Generation, 10) }
+
+// RolloutStatus never reports failure: DaemonSets have no progress deadline
+func (w daemonSetWorkload) RolloutStatus() (bool, bool) {
+	desired := w.DesiredReplicas()
+	complete := w.Status.ObservedGeneration >= w.Generation &&
+		w.Status.UpdatedNumberScheduled == desired &&
+		w.Status.NumberAvailable == desired
+	return complete, false
+}
+
+// rolloutWorkload wraps an Argo Rollout, which we only see as unstructured content
+type rolloutWorkload struct {
+	*unstructured.Unstructured
+}
+
+func (w rolloutWorkload) Kind() string { return KindRollout }
+
+// Images is empty for rollouts referencing a workload instead of holding a template
+func (w rolloutWorkload) Images() []string { return containerImages(w.PodTemplate().Spec) }
+
+func (w rolloutWorkload) PodSelector() *metav1.LabelSelector {
+	var selector metav1.LabelSelector
+	content, _, _ := unstructured.NestedMap(w.Object, "spec", "selector")
+	runtime.DefaultUnstructuredConverter.FromUnstructured(content, &selector)
+	return &selector
+}
+
+func (w rolloutWorkload) PodTemplate() corev1.PodTemplateSpec {
+	var template corev1.PodTemplateSpec
+	content, _, _ := unstructured.NestedMap(w.Object, "spec", "template")
+	runtime.DefaultUnstructuredConverter.FromUnstructured(content, &template)
+	return template
+}
+
+func (w rolloutWorkload) DesiredReplicas() int32 {
+	replicas, found, err := unstructured.NestedInt64(w.Object, "spec", "replicas")
+	if err != nil || !found {
+		return 1
+	}
+	return int32(replicas)
+}
+
+func (w rolloutWorkload) ReadyReplicas() int32 {
+	replicas, _, _ := unstructured.NestedInt64(w.Object, "status", "readyReplicas")
+	return int32(replicas)
+}
+
+func (w rolloutWorkload) Revision() string {
+	hash, _, _ := unstructured.NestedString(w.Object, "status", "currentPodHash")
+	return hash
+}
+
+// RolloutStatus relies on the phase Argo Rollouts computes; Degraded covers
+// both an exceeded progress deadline and aborted rollouts
+func (w rolloutWorkload) RolloutStatus() (bool, bool) {
+	phase, _, _ := unstructured.NestedString(w.Object, "status", "phase")
+	return phase == "Healthy", phase == "Degraded"
+}
+
+// asWorkload wraps an informer object in the matching Workload implementation
+func asWorkload(obj interface{}) (Workload, error) {
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		return deploymentWorkload{o}, nil
+	case *appsv1.StatefulSet:
+		return statefulSetWorkload{o}, nil
+	case *appsv1.DaemonSet:
+		return daemonSetWorkload{o}, nil
+	case *unstructured.Unstructured:
+		if o.GetKind() == KindRollout {
+			return rolloutWorkload{o}, nil
+		}
+		return nil, fmt.Errorf("unsupported kind %s", o.GetKind())
+	}
+	return nil, fmt.Errorf("unsupported object type %T", obj)
+}
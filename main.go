@@ -1,32 +1,85 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	dorametrics "github.com/gocityengineering/dora-metrics/dorametrics"
 	au "github.com/logrusorgru/aurora"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
-	appsv1 "k8s.io/api/apps/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	rest "k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/workqueue"
 )
 
-const labelPrefix = "dora-controller"
-const labelNameEnabled = "enabled"
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// options holds the settings that go beyond cluster access and debugging
+type options struct {
+	stateStore            string
+	stateNamespace        string
+	stateConfigMap        string
+	stateFile             string
+	leaderElect           bool
+	leaseName             string
+	leaseNamespace        string
+	leaseDuration         time.Duration
+	renewDeadline         time.Duration
+	retryPeriod           time.Duration
+	collectors            dorametrics.CollectorOptions
+	kinds                 []string
+	ingestSecret          string
+	ingestHMACKey         string
+	configPath            string
+	configReloadInterval  time.Duration
+	pagerDutyKey          string
+	pagerDutyURL          string
+	defaultTeam           string
+	defaultEnvironment    string
+	availabilityThreshold float64
+	openGracePeriod       time.Duration
+	closeGracePeriod      time.Duration
+	changeFailureWindow   time.Duration
+	maxCycleTime          int64
+	maxTimeToRecovery     int64
+	deletionRetention     time.Duration
+	eventLog              string
+	eventLogFile          string
+	eventLogMaxSizeMB     int64
+	eventLogMaxFiles      int
+	eventStore            string
+	eventStoreRetention   time.Duration
+	performanceWindow     time.Duration
+	performanceInterval   time.Duration
+	webhookPort           int
+	webhookCertFile       string
+	webhookKeyFile        string
+	webhookReportWindow   time.Duration
+	alertNamespaceLabel   string
+	alertServiceLabel     string
+	alertTeamLabel        string
+	pagerDutyWebhookKey   string
+	opsgenieWebhookKey    string
+}
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "report" {
+		os.Exit(reportMain(os.Args[2:]))
+	}
+
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, `Usage: %s`, filepath.Base(os.Args[0]))
 		flag.PrintDefaults()
@@ -37,25 +90,127 @@ func main() {
 	master := flag.String("master", "", "master url")
 	debug := flag.Bool("debug", false, "debug mode")
 
-	flag.Parse()
+	var opts options
+	flag.StringVar(&opts.stateStore, "state-store", "none", "where to persist controller state across restarts: none, configmap or file")
+	flag.StringVar(&opts.stateNamespace, "state-namespace", "", "namespace of the state configmap (defaults to the controller's namespace)")
+	flag.StringVar(&opts.stateConfigMap, "state-configmap", "dora-metrics-state", "name of the state configmap")
+	flag.StringVar(&opts.stateFile, "state-file", "dora-metrics-state.json", "path of the state file")
+	flag.BoolVar(&opts.leaderElect, "leader-elect", false, "elect a leader among controller replicas; only the leader processes deployments")
+	flag.StringVar(&opts.leaseName, "lease-name", "dora-metrics", "name of the leader election lease")
+	flag.StringVar(&opts.leaseNamespace, "lease-namespace", "", "namespace of the leader election lease (defaults to the controller's namespace)")
+	flag.DurationVar(&opts.leaseDuration, "lease-duration", 15*time.Second, "how long followers wait before trying to take over the lease")
+	flag.DurationVar(&opts.renewDeadline, "renew-deadline", 10*time.Second, "how long the leader keeps retrying to renew the lease")
+	flag.DurationVar(&opts.retryPeriod, "retry-period", 2*time.Second, "interval between leader election attempts")
+	flag.StringVar(&opts.ingestSecret, "ingest-secret", os.Getenv("DORA_INGEST_SECRET"), "bearer token accepted by the ingestion endpoints (env DORA_INGEST_SECRET)")
+	flag.StringVar(&opts.ingestHMACKey, "ingest-hmac-key", os.Getenv("DORA_INGEST_HMAC_KEY"), "key for HMAC-SHA256 request signatures accepted by the ingestion endpoints (env DORA_INGEST_HMAC_KEY)")
+	flag.StringVar(&opts.configPath, "config", "", "path to the YAML configuration file listing targets")
+	flag.DurationVar(&opts.configReloadInterval, "config-reload-interval", 30*time.Second, "how often to check the configuration file for changes (0 disables reloading)")
+	flag.StringVar(&opts.pagerDutyKey, "pagerduty-routing-key", os.Getenv("PAGERDUTY_ROUTING_KEY"), "PagerDuty Events API v2 routing key for targets with alert: true (env PAGERDUTY_ROUTING_KEY)")
+	flag.StringVar(&opts.pagerDutyURL, "pagerduty-url", "", "PagerDuty Events API v2 endpoint (defaults to PagerDuty's)")
+	flag.StringVar(&opts.defaultTeam, "default-team", "", "team label for workloads without a team (defaults to unknown)")
+	flag.StringVar(&opts.defaultEnvironment, "default-environment", "", "environment label for workloads without an environment (defaults to unknown)")
+	flag.DurationVar(&opts.openGracePeriod, "open-grace-period", 0, "how long a workload must be unavailable before an outage opens")
+	flag.DurationVar(&opts.closeGracePeriod, "close-grace-period", 0, "how long a workload must be fully available before an outage closes")
+	flag.DurationVar(&opts.changeFailureWindow, "change-failure-window", time.Hour, "how long after a successful deployment an outage counts as its change failure (0 disables)")
+	flag.Int64Var(&opts.maxCycleTime, "max-cycle-time-seconds", 7200, "cap on reported cycle times (0 disables the cap)")
+	flag.Int64Var(&opts.maxTimeToRecovery, "max-time-to-recovery-seconds", 7200, "cap on reported times to recovery (0 disables the cap)")
+	flag.DurationVar(&opts.deletionRetention, "deletion-retention", 0, "how long to keep metric series and state of deleted workloads")
+	flag.StringVar(&opts.eventLog, "event-log", "none", "where to write DORA events as JSON Lines: none, stdout or file")
+	flag.StringVar(&opts.eventLogFile, "event-log-file", "dora-events.jsonl", "path of the event log file")
+	flag.Int64Var(&opts.eventLogMaxSizeMB, "event-log-max-size-mb", 100, "size in megabytes at which the event log file is rotated (0 disables rotation)")
+	flag.IntVar(&opts.eventLogMaxFiles, "event-log-max-files", 5, "number of rotated event log files to keep")
+	flag.StringVar(&opts.eventStore, "event-store", "", "path of the event store database the query API reads (empty disables the query API)")
+	flag.DurationVar(&opts.eventStoreRetention, "event-store-retention", 365*24*time.Hour, "how long the event store keeps events for queries (0 keeps them forever)")
+	flag.DurationVar(&opts.performanceWindow, "performance-window", 30*24*time.Hour, "rolling window over which performance tiers are computed from the event store")
+	flag.DurationVar(&opts.performanceInterval, "performance-interval", 5*time.Minute, "how often to recompute performance tiers")
+	flag.IntVar(&opts.webhookPort, "webhook-port", 0, "TLS port serving the admission webhooks /validate and /mutate (0 disables them)")
+	flag.StringVar(&opts.webhookCertFile, "webhook-cert-file", "", "path of the admission webhook TLS certificate")
+	flag.StringVar(&opts.webhookKeyFile, "webhook-key-file", "", "path of the admission webhook TLS key")
+	flag.DurationVar(&opts.webhookReportWindow, "webhook-report-window", 10*time.Minute, "how far ahead the mutating webhook sets a missing report-before (0 leaves it missing)")
+	flag.StringVar(&opts.alertNamespaceLabel, "alert-namespace-label", "namespace", "alert label holding the namespace of the affected workload")
+	flag.StringVar(&opts.alertServiceLabel, "alert-service-label", "service", "alert label holding the affected service")
+	flag.StringVar(&opts.alertTeamLabel, "alert-team-label", "team", "alert label holding the team owning the affected service")
+	flag.StringVar(&opts.pagerDutyWebhookKey, "pagerduty-webhook-secret", os.Getenv("PAGERDUTY_WEBHOOK_SECRET"), "secret of the PagerDuty v3 webhook subscription, enabling /api/v1/webhooks/pagerduty (env PAGERDUTY_WEBHOOK_SECRET)")
+	flag.StringVar(&opts.opsgenieWebhookKey, "opsgenie-webhook-secret", os.Getenv("OPSGENIE_WEBHOOK_SECRET"), "bearer token sent by the Opsgenie webhook integration, enabling /api/v1/webhooks/opsgenie (env OPSGENIE_WEBHOOK_SECRET)")
+	availabilityThreshold := flag.String("availability-threshold", "0", "share of ready replicas (e.g. 0.5 or 50%) below which a workload counts as degraded; 0 only tracks workloads that are down")
+	kinds := flag.String("kinds", "deployments,statefulsets,daemonsets", "comma-separated workload resources to watch: deployments, statefulsets, daemonsets, rollouts")
+	cycleTimeBuckets := flag.String("cycle-time-buckets", "", "comma-separated cycle time histogram buckets in seconds")
+	timeToRecoveryBuckets := flag.String("time-to-recovery-buckets", "", "comma-separated time to recovery histogram buckets in seconds")
+	leadTimeBuckets := flag.String("lead-time-buckets", "", "comma-separated lead time histogram buckets in seconds")
 
-	os.Exit(realMain(*kubeconfig, *master, *debug, false))
-}
+	flag.Parse()
 
-func realMain(kubeconfig, master string, debug, dryrun bool) int {
-	// register collectors
-	var collectors = dorametrics.Collectors{}
-	err := dorametrics.RegisterCollectors(&collectors, dryrun)
+	var err error
+	opts.kinds, err = dorametrics.ParseKinds(*kinds)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, `Can't register collectors: %v`, err)
-		return 1
+		fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Invalid --kinds")), err)
+		os.Exit(1)
+	}
+	opts.availabilityThreshold, err = dorametrics.ParseThreshold(*availabilityThreshold)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Invalid --availability-threshold")), err)
+		os.Exit(1)
+	}
+	if opts.maxCycleTime < 0 || opts.maxTimeToRecovery < 0 {
+		fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Invalid --max-cycle-time-seconds or --max-time-to-recovery-seconds")), "must not be negative")
+		os.Exit(1)
+	}
+	if opts.changeFailureWindow < 0 {
+		fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Invalid --change-failure-window")), "must not be negative")
+		os.Exit(1)
+	}
+	if opts.eventStoreRetention < 0 {
+		fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Invalid --event-store-retention")), "must not be negative")
+		os.Exit(1)
+	}
+	if opts.performanceWindow <= 0 || opts.performanceInterval <= 0 {
+		fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Invalid --performance-window or --performance-interval")), "must be positive")
+		os.Exit(1)
+	}
+	if opts.webhookPort > 0 && (len(opts.webhookCertFile) == 0 || len(opts.webhookKeyFile) == 0) {
+		fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Invalid --webhook-port")), "requires --webhook-cert-file and --webhook-key-file")
+		os.Exit(1)
+	}
+	if len(*cycleTimeBuckets) > 0 {
+		opts.collectors.CycleTimeBuckets, err = dorametrics.ParseBuckets(*cycleTimeBuckets)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Invalid --cycle-time-buckets")), err)
+			os.Exit(1)
+		}
+	}
+	if len(*timeToRecoveryBuckets) > 0 {
+		opts.collectors.TimeToRecoveryBuckets, err = dorametrics.ParseBuckets(*timeToRecoveryBuckets)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Invalid --time-to-recovery-buckets")), err)
+			os.Exit(1)
+		}
+	}
+	if len(*leadTimeBuckets) > 0 {
+		opts.collectors.LeadTimeBuckets, err = dorametrics.ParseBuckets(*leadTimeBuckets)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Invalid --lead-time-buckets")), err)
+			os.Exit(1)
+		}
 	}
 
-	// API requests will fail unless we set InsecureSkipVerify to true
-	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	os.Exit(realMain(*kubeconfig, *master, *debug, false, opts))
+}
 
-	// set up controller
+// controllerNamespace returns the namespace the controller runs in, if known
+func controllerNamespace() string {
+	if namespace := os.Getenv("POD_NAMESPACE"); len(namespace) > 0 {
+		return namespace
+	}
+	bytes, err := ioutil.ReadFile(serviceAccountNamespaceFile)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(bytes))
+}
 
+// restConfig resolves cluster access from the kubeconfig flag, KUBECONFIG or
+// the in-cluster service account; a non-zero code is the exit code on failure
+func restConfig(kubeconfig, master string) (*rest.Config, int) {
 	// support out-of-cluster deployments (param, env var only)
 	if len(kubeconfig) == 0 {
 		kubeconfig = os.Getenv("KUBECONFIG")
@@ -68,16 +223,38 @@ func realMain(kubeconfig, master string, debug, dryrun bool) int {
 		config, configError = clientcmd.BuildConfigFromFlags(master, kubeconfig)
 		if configError != nil {
 			fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Out-of-cluster error")), configError)
-			return 2
+			return nil, 2
 		}
 	} else {
 		config, configError = rest.InClusterConfig()
 		if configError != nil {
 			fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("In-cluster error")), configError)
-			return 3
+			return nil, 3
 		}
 	}
 
+	return config, 0
+}
+
+func realMain(kubeconfig, master string, debug, dryrun bool, opts options) int {
+	// register collectors
+	var collectors = dorametrics.Collectors{}
+	err := dorametrics.RegisterCollectors(&collectors, opts.collectors, dryrun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, `Can't register collectors: %v`, err)
+		return 1
+	}
+
+	// API requests will fail unless we set InsecureSkipVerify to true
+	http.DefaultTransport.(*http.Transport).TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+
+	// set up controller
+
+	config, code := restConfig(kubeconfig, master)
+	if code != 0 {
+		return code
+	}
+
 	// create clientset
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
@@ -85,62 +262,221 @@ func realMain(kubeconfig, master string, debug, dryrun bool) int {
 		return 4
 	}
 
+	// the dynamic client covers custom resources such as Argo Rollouts
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Error")), err)
+		return 4
+	}
+
+	// set up state persistence
+	if len(opts.stateNamespace) == 0 {
+		opts.stateNamespace = controllerNamespace()
+	}
+	store, err := dorametrics.NewStateStore(opts.stateStore, clientset, opts.stateNamespace, opts.stateConfigMap, opts.stateFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("State store error")), err)
+		return 5
+	}
+
+	eventLog, err := dorametrics.NewEventLog(opts.eventLog, opts.eventLogFile, opts.eventLogMaxSizeMB*1024*1024, opts.eventLogMaxFiles)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Event log error")), err)
+		return 9
+	}
+	var eventStore *dorametrics.EventStore
+	if len(opts.eventStore) > 0 {
+		eventStore, err = dorametrics.OpenEventStore(opts.eventStore, opts.eventStoreRetention)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Event store error")), err)
+			return 9
+		}
+		defer eventStore.Close()
+		if eventLog == nil {
+			eventLog = eventStore
+		} else {
+			eventLog = dorametrics.MultiEventLog{eventLog, eventStore}
+		}
+	}
+
+	var targetConfig *dorametrics.ControllerConfig
+	if len(opts.configPath) > 0 {
+		targetConfig, err = dorametrics.LoadConfig(opts.configPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Configuration error")), err)
+			return 8
+		}
+	}
+
 	var mutex = &sync.Mutex{}
 	var state = map[string]dorametrics.DeploymentInfo{}
 
-	labelKey := fmt.Sprintf("%s/%s", labelPrefix, labelNameEnabled)
-	labelValue := "true"
-	deploymentSelector := labels.SelectorFromSet(labels.Set(map[string]string{labelKey: labelValue})).String()
-	optionsModifier := func(options *metav1.ListOptions) {
-		options.LabelSelector = deploymentSelector
+	deploymentSelector := labels.SelectorFromSet(labels.Set(map[string]string{dorametrics.EnabledLabel: "true"})).String()
+	if targetConfig.ExtendsTracking() {
+		// added targets need not carry the label; the controller filters instead
+		deploymentSelector = ""
 	}
-	deploymentListWatcher := cache.NewFilteredListWatchFromClient(
-		clientset.AppsV1().RESTClient(),
-		"deployments",
-		metav1.NamespaceAll,
-		optionsModifier)
 
 	queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
 
-	indexer, informer := cache.NewIndexerInformer(deploymentListWatcher, &appsv1.Deployment{}, 0, cache.ResourceEventHandlerFuncs{
-		AddFunc: func(obj interface{}) {
-			key, err := cache.MetaNamespaceKeyFunc(obj)
-			if err == nil {
-				queue.Add(key)
-			}
-		},
-		UpdateFunc: func(old interface{}, new interface{}) {
-			key, err := cache.MetaNamespaceKeyFunc(new)
-			if err == nil {
-				queue.Add(key)
-			}
-		},
-		DeleteFunc: func(obj interface{}) {
-			key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
-			if err == nil {
-				queue.Add(key)
-			}
-		},
-	}, cache.Indexers{})
+	indexers, informers, err := dorametrics.NewWorkloadInformers(clientset, dynamicClient, opts.kinds, deploymentSelector, queue)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Informer error")), err)
+		return 4
+	}
+	namespaces, namespaceInformer := dorametrics.NewNamespaceInformer(clientset)
+	informers = append(informers, namespaceInformer)
 
 	dedup := make(map[string]string)
 	controller := dorametrics.NewController(
 		queue,
-		indexer,
-		informer,
+		indexers,
+		informers,
 		clientset,
 		mutex,
 		state,
 		dedup,
+		store,
 		debug,
 		&collectors)
 
+	controller.Namespaces = namespaces
+	controller.DefaultTeam = opts.defaultTeam
+	controller.DefaultEnvironment = opts.defaultEnvironment
+	controller.DefaultAvailabilityThreshold = opts.availabilityThreshold
+	controller.DefaultOpenGracePeriod = opts.openGracePeriod
+	controller.DefaultCloseGracePeriod = opts.closeGracePeriod
+	controller.DefaultChangeFailureWindow = opts.changeFailureWindow
+	controller.MaxCycleTimeSeconds = opts.maxCycleTime
+	controller.MaxTimeToRecoverySeconds = opts.maxTimeToRecovery
+	controller.DeletionRetention = opts.deletionRetention
+	controller.EventLog = eventLog
+	controller.EventStore = eventStore
+	controller.PerformanceWindow = opts.performanceWindow
+	controller.Config = targetConfig
+	controller.WatchesUnlabelled = len(deploymentSelector) == 0
+	if len(opts.pagerDutyKey) > 0 {
+		controller.Alerter = dorametrics.NewAlerter(opts.pagerDutyURL, opts.pagerDutyKey)
+	}
+
+	http.Handle("/metrics", promhttp.Handler())
+
+	deployments := dorametrics.MethodHandler{}
+	// only the leader may change state; followers refuse requests that would
+	leaderOnly := func(handler http.Handler) http.Handler {
+		return &dorametrics.LeaderHandler{Controller: controller, Handler: handler}
+	}
+	// the ingestion endpoints stay disabled unless requests can be authenticated
+	if len(opts.ingestSecret) > 0 || len(opts.ingestHMACKey) > 0 {
+		deployments[http.MethodPost] = leaderOnly(&dorametrics.IngestHandler{
+			Controller: controller,
+			Secret:     opts.ingestSecret,
+			HMACKey:    opts.ingestHMACKey,
+		})
+		http.Handle("/api/v1/events", leaderOnly(&dorametrics.CloudEventsHandler{
+			Controller: controller,
+			Secret:     opts.ingestSecret,
+			HMACKey:    opts.ingestHMACKey,
+		}))
+		http.Handle("/api/v1/alerts", leaderOnly(&dorametrics.AlertmanagerHandler{
+			Controller:     controller,
+			Secret:         opts.ingestSecret,
+			HMACKey:        opts.ingestHMACKey,
+			NamespaceLabel: opts.alertNamespaceLabel,
+			ServiceLabel:   opts.alertServiceLabel,
+			TeamLabel:      opts.alertTeamLabel,
+		}))
+	}
+	if len(opts.pagerDutyWebhookKey) > 0 {
+		http.Handle("/api/v1/webhooks/pagerduty", leaderOnly(&dorametrics.PagerDutyHandler{
+			Controller: controller,
+			Secret:     opts.pagerDutyWebhookKey,
+		}))
+	}
+	if len(opts.opsgenieWebhookKey) > 0 {
+		http.Handle("/api/v1/webhooks/opsgenie", leaderOnly(&dorametrics.OpsgenieHandler{
+			Controller: controller,
+			Secret:     opts.opsgenieWebhookKey,
+		}))
+	}
+	if eventStore != nil {
+		deployments[http.MethodGet] = &dorametrics.DeploymentsQueryHandler{Store: eventStore}
+		http.Handle("/api/v1/incidents", dorametrics.MethodHandler{
+			http.MethodGet: &dorametrics.IncidentsQueryHandler{Store: eventStore},
+		})
+		http.Handle("/api/v1/performance", dorametrics.MethodHandler{
+			http.MethodGet: &dorametrics.PerformanceHandler{Store: eventStore, Window: opts.performanceWindow},
+		})
+	}
+	if len(deployments) > 0 {
+		http.Handle("/api/v1/deployments", deployments)
+	}
+
+	// admission webhooks need TLS, so they get a port of their own
+	if opts.webhookPort > 0 {
+		webhooks := http.NewServeMux()
+		webhooks.Handle("/validate", &dorametrics.AdmissionHandler{})
+		webhooks.Handle("/mutate", &dorametrics.AdmissionHandler{Mutate: true, ReportWindow: opts.webhookReportWindow})
+		// renewed certificates are picked up without a restart
+		certificates, err := dorametrics.NewCertificateReloader(opts.webhookCertFile, opts.webhookKeyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Admission webhook error")), err)
+			return 10
+		}
+		server := &http.Server{
+			Addr:      fmt.Sprintf(":%d", opts.webhookPort),
+			Handler:   webhooks,
+			TLSConfig: &tls.Config{GetCertificate: certificates.GetCertificate},
+		}
+		go func() {
+			err := server.ListenAndServeTLS("", "")
+			fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Admission webhook error")), err)
+		}()
+	}
+
 	stop := make(chan struct{})
 	defer close(stop)
-	go controller.Run(1, stop)
+	if len(opts.configPath) > 0 && opts.configReloadInterval > 0 {
+		go controller.WatchConfig(opts.configPath, opts.configReloadInterval, stop)
+	}
+	if eventStore != nil {
+		go controller.WatchPerformance(opts.performanceInterval, stop)
+	}
 
-	http.Handle("/metrics", promhttp.Handler())
-	http.ListenAndServe(":2112", nil)
+	if !opts.leaderElect {
+		go controller.Run(1, stop)
+
+		http.ListenAndServe(":2112", nil)
+		return 0
+	}
+
+	// followers keep their informer cache warm and serve /metrics
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go controller.RunFollower(ctx.Done())
+	go http.ListenAndServe(":2112", nil)
+
+	if len(opts.leaseNamespace) == 0 {
+		opts.leaseNamespace = controllerNamespace()
+	}
+	identity, err := os.Hostname()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Leader election error")), err)
+		return 6
+	}
+	err = dorametrics.RunLeaderElection(ctx, clientset, dorametrics.LeaderElectionConfig{
+		LeaseName:      opts.leaseName,
+		LeaseNamespace: opts.leaseNamespace,
+		Identity:       identity,
+		LeaseDuration:  opts.leaseDuration,
+		RenewDeadline:  opts.renewDeadline,
+		RetryPeriod:    opts.retryPeriod,
+	}, controller, 1)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Leader election error")), err)
+		return 6
+	}
 
-	return 0
+	// leadership lost: exit so we restart as a follower with fresh state
+	return 7
 }
@@ -20,7 +20,31 @@ func TestRealMain(t *testing.T) {
 
 	for _, test := range tests {
 		t.Run(test.description, func(t *testing.T) {
-			retVal := realMain(test.kubeconfig, test.master, test.debug, test.dryrun)
+			retVal := realMain(test.kubeconfig, test.master, test.debug, test.dryrun, options{})
+			if retVal != test.expected {
+				t.Errorf("%s: unexpected return value '%d'; expected '%d'", test.description, retVal, test.expected)
+			}
+		})
+	}
+}
+
+func TestReportMain(t *testing.T) {
+	var tests = []struct {
+		description string
+		args        []string
+		expected    int
+	}{
+		{"dry run", []string{"--started-at", "2021-07-18T09:00:00Z", "--outcome", "success", "--dry-run", "server-a"}, 0},
+		{"missing name", []string{"--started-at", "1626599875", "--outcome", "success"}, 1},
+		{"misspelt outcome", []string{"--started-at", "1626599875", "--outcome", "sucess", "server-a"}, 1},
+		{"missing start", []string{"--outcome", "failure", "--dry-run", "server-a"}, 1},
+		{"invalid kind", []string{"--kind", "job", "--started-at", "1626599875", "--outcome", "success", "server-a"}, 1},
+		{"nonblank kubeconfig", []string{"--kubeconfig", "nonesuch", "--started-at", "1626599875", "--outcome", "success", "server-a"}, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			retVal := reportMain(test.args)
 			if retVal != test.expected {
 				t.Errorf("%s: unexpected return value '%d'; expected '%d'", test.description, retVal, test.expected)
 			}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	dorametrics "github.com/gocityengineering/dora-metrics/dorametrics"
+	au "github.com/logrusorgru/aurora"
+
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+// reportMain implements "dora-metrics report", which annotates a workload
+// with the outcome of a deployment for the controller to pick up
+func reportMain(args []string) int {
+	flags := flag.NewFlagSet("report", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: dora-metrics report [flags] NAME\n")
+		flags.PrintDefaults()
+	}
+	kubeconfig := flags.String("kubeconfig", "", "absolute path to the kubeconfig file")
+	master := flags.String("master", "", "master url")
+	namespace := flags.String("namespace", "default", "namespace of the workload")
+	kind := flags.String("kind", "deployment", "workload resource: deployment, statefulset, daemonset or rollout")
+	startedAt := flags.String("started-at", "", "when the pipeline was triggered, as unix seconds or RFC 3339 (required)")
+	outcome := flags.String("outcome", "", "outcome of the deployment: success or failure (required)")
+	commitSHA := flags.String("commit-sha", "", "commit deployed")
+	commitTimestamps := flags.String("commit-timestamps", "", "comma-separated unix timestamps of the commits deployed, for lead time")
+	window := flags.Duration("report-window", 10*time.Minute, "how long the controller has to pick up the report, including pod verification")
+	dryrun := flags.Bool("dry-run", false, "print the patch instead of applying it")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	invalid := func(flag string, err interface{}) int {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", au.Bold(au.Red(fmt.Sprintf("Invalid %s", flag))), err)
+		return 1
+	}
+	if flags.NArg() != 1 {
+		return invalid("arguments", "expected the workload name")
+	}
+	report := dorametrics.Report{
+		Namespace: *namespace,
+		Name:      flags.Arg(0),
+		CommitSHA: *commitSHA,
+		Window:    *window,
+	}
+
+	var err error
+	report.Kind, err = dorametrics.ParseKind(*kind)
+	if err != nil {
+		return invalid("--kind", err)
+	}
+	switch *outcome {
+	case "success":
+		report.Success = true
+	case "failure":
+	default:
+		return invalid("--outcome", fmt.Sprintf("%q must be success or failure", *outcome))
+	}
+	report.StartedAt, err = dorametrics.ParseTime(*startedAt)
+	if err != nil {
+		return invalid("--started-at", err)
+	}
+	for _, item := range splitList(*commitTimestamps) {
+		timestamp, err := dorametrics.ParseTime(item)
+		if err != nil {
+			return invalid("--commit-timestamps", err)
+		}
+		report.CommitTimestamps = append(report.CommitTimestamps, timestamp)
+	}
+
+	now := time.Now()
+	if *dryrun {
+		annotations, err := report.Annotations(now)
+		if err != nil {
+			return invalid("report", err)
+		}
+		patch, err := dorametrics.ReportPatch(annotations)
+		if err != nil {
+			return invalid("report", err)
+		}
+		fmt.Printf("%s\n", patch)
+		return 0
+	}
+
+	config, code := restConfig(*kubeconfig, *master)
+	if code != 0 {
+		return code
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Error")), err)
+		return 4
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s", au.Bold(au.Red("Error")), err)
+		return 4
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := dorametrics.ApplyReport(ctx, clientset, dynamicClient, report, now); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", au.Bold(au.Red("Report error")), err)
+		return 10
+	}
+	fmt.Printf("reported %s of %s %s in namespace %s\n", *outcome, report.Kind, report.Name, report.Namespace)
+	return 0
+}
+
+// splitList splits a comma-separated list, dropping blank items
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}